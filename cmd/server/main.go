@@ -2,18 +2,32 @@ package main
 
 import (
 	"context"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/archive"
+	"github.com/nemouu/cairn/internal/attachments"
+	"github.com/nemouu/cairn/internal/bookmarks"
 	"github.com/nemouu/cairn/internal/database"
 	"github.com/nemouu/cairn/internal/entries"
+	"github.com/nemouu/cairn/internal/markdown"
 	"github.com/nemouu/cairn/internal/notes"
 	"github.com/nemouu/cairn/internal/todos"
 )
 
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
 	// Connect to database
 	pool, err := database.Connect(ctx)
@@ -26,6 +40,13 @@ func main() {
 	if err := database.RunMigrations(ctx, pool, "migrations"); err != nil {
 		log.Fatal(err)
 	}
+	if n, err := bookmarks.BackfillNormalizedURLs(ctx, pool); err != nil {
+		log.Fatal(err)
+	} else if n > 0 {
+		log.Printf("normalized %d bookmark URLs", n)
+	}
+
+	attachmentStore := newAttachmentStore(pool)
 
 	// Set up routes
 	mux := http.NewServeMux()
@@ -34,6 +55,36 @@ func main() {
 	mux.Handle("GET /static/", http.StripPrefix("/static/",
 		http.FileServer(http.Dir("static"))))
 
+	// Bookmark link checker, shared by manual and background checks
+	checkOpts := bookmarks.Options{
+		UserAgent: envString("CHECK_USER_AGENT", bookmarks.DefaultUserAgent),
+		HeadFirst: envBool("CHECK_HEAD_FIRST", true),
+		Hosts: bookmarks.NewHostLimiter(
+			envInt("CHECK_PER_HOST", 2),
+			envDuration("CHECK_HOST_DELAY", time.Second),
+		),
+	}
+	if envBool("CHECK_RESPECT_ROBOTS", false) {
+		checkOpts.Robots = bookmarks.NewRobotsCache(24 * time.Hour)
+	}
+	archiver := newArchiver(pool, checkOpts)
+	checker := bookmarks.NewChecker(bookmarks.PoolSink{
+		Pool:        pool,
+		AutoRewrite: envBool("BOOKMARKS_AUTO_REWRITE", false),
+		Archiver:    archiver,
+	}, checkOpts)
+	checker.CertWarning = time.Duration(envInt("CERT_WARN_DAYS", 14)) * 24 * time.Hour
+	checkInterval := envDuration("CHECK_INTERVAL", 24*time.Hour)
+	if checkInterval <= 0 {
+		log.Fatalf("CHECK_INTERVAL must be positive, not %s", checkInterval)
+	}
+	scheduler := bookmarks.NewScheduler(pool, checker, checkInterval,
+		envInt("CHECK_WORKERS", 4),
+	)
+	if archiver != nil {
+		scheduler.ArchiveTimeout = archiver.CaptureTimeout()
+	}
+
 	// Dashboard
 	mux.HandleFunc("GET /", func(w http.ResponseWriter, r *http.Request) {
 		entryList, err := entries.ListAll(r.Context(), pool)
@@ -41,6 +92,16 @@ func main() {
 			http.Error(w, "database error", http.StatusInternalServerError)
 			return
 		}
+		expiring, err := bookmarks.ListExpiringCerts(r.Context(), pool, checker.CertWarning)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		due, err := todos.ListDue(r.Context(), pool)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
 
 		tmpl, err := template.ParseFiles("templates/layout.html", "templates/home.html")
 		if err != nil {
@@ -49,17 +110,197 @@ func main() {
 		}
 
 		data := map[string]any{
-			"Title":   "Dashboard",
-			"Entries": entryList,
+			"Title":         "Dashboard",
+			"Entries":       entryList,
+			"ExpiringCerts": expiring,
+			"Overdue":       todos.GroupDue(due).Overdue,
 		}
 		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
 			log.Println("template render error:", err)
 		}
 	})
 
-	notes.RegisterRoutes(mux, pool)
+	notes.RegisterRoutes(mux, pool, markdown.New(256))
 	todos.RegisterRoutes(mux, pool)
+	bookmarks.RegisterRoutes(mux, pool, checker, scheduler, archiver)
+	attachments.RegisterRoutes(mux, pool, attachmentStore, int64(envInt("ATTACHMENT_MAX_MB", 25))<<20)
+
+	// Background link checks
+	var background sync.WaitGroup
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		scheduler.Run(ctx)
+	}()
+
+	// Reminders of todo items falling due
+	reminders := todos.NewReminders(pool, newNotifier(),
+		envDuration("REMINDER_LEAD", 15*time.Minute),
+	)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		reminders.Run(ctx)
+	}()
+
+	// Lists made from recurring todo templates
+	recurrer := todos.NewRecurrer(pool)
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		recurrer.Run(ctx)
+	}()
+
+	// Files left behind by deleted entries and bookmarks
+	sweepInterval := envDuration("SWEEP_INTERVAL", time.Hour)
+	if sweepInterval <= 0 {
+		log.Fatalf("SWEEP_INTERVAL must be positive, not %s", sweepInterval)
+	}
+	background.Add(1)
+	go func() {
+		defer background.Done()
+		sweepEvery(ctx, sweepInterval, "attachment files", func(ctx context.Context) (int, error) {
+			return attachments.Sweep(ctx, pool, attachmentStore)
+		})
+	}()
+	if archiver != nil {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			sweepEvery(ctx, sweepInterval, "page archives", func(ctx context.Context) (int, error) {
+				return bookmarks.SweepArchives(ctx, pool, archiver.Store)
+			})
+		}()
+	}
+
+	server := &http.Server{Addr: ":8080", Handler: mux}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Println("shutdown error:", err)
+		}
+	}()
 
 	log.Println("listening on :8080")
-	log.Fatal(http.ListenAndServe(":8080", mux))
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		log.Fatal(err)
+	}
+
+	background.Wait()
+}
+
+// sweepEvery runs sweep now and then every interval until ctx is
+// cancelled, logging what it removed.
+func sweepEvery(ctx context.Context, interval time.Duration, what string, sweep func(context.Context) (int, error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if n, err := sweep(ctx); err != nil {
+			if ctx.Err() == nil {
+				log.Printf("sweeping %s: %v", what, err)
+			}
+		} else if n > 0 {
+			log.Printf("removed %d unused %s", n, what)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// newArchiver configures page archiving from ARCHIVE_MODE ("html", "text"
+// or "off"), ARCHIVE_DIR and ARCHIVE_TIMEOUT. Archives go to Postgres unless a directory is
+// given.
+func newArchiver(pool *pgxpool.Pool, opts bookmarks.Options) *archive.Archiver {
+	mode := archive.Mode(envString("ARCHIVE_MODE", string(archive.ModeHTML)))
+	if mode == "off" {
+		return nil
+	}
+
+	var store archive.Store = archive.PostgresStore{Pool: pool}
+	if dir := os.Getenv("ARCHIVE_DIR"); dir != "" {
+		store = archive.DirStore{Dir: dir}
+	}
+
+	return &archive.Archiver{
+		Store:   store,
+		Client:  opts.Client(nil, 30*time.Second),
+		Mode:    mode,
+		Timeout: envDuration("ARCHIVE_TIMEOUT", archive.DefaultTimeout),
+	}
+}
+
+// newAttachmentStore keeps attachments in ATTACHMENT_DIR, or as Postgres
+// large objects if ATTACHMENT_STORE is "postgres".
+func newAttachmentStore(pool *pgxpool.Pool) attachments.Store {
+	if envString("ATTACHMENT_STORE", "dir") == "postgres" {
+		return attachments.LargeObjectStore{Pool: pool}
+	}
+	return attachments.DirStore{Dir: envString("ATTACHMENT_DIR", "attachments")}
+}
+
+// newNotifier posts reminders to REMINDER_WEBHOOK_URL if it is set, and
+// logs them otherwise. PUBLIC_URL makes the links in them absolute.
+func newNotifier() todos.Notifier {
+	url := os.Getenv("REMINDER_WEBHOOK_URL")
+	if url == "" {
+		return todos.LogNotifier{}
+	}
+	return todos.WebhookNotifier{
+		URL:     url,
+		BaseURL: strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/"),
+		Client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func envString(name, fallback string) string {
+	if v := os.Getenv(name); v != "" {
+		return v
+	}
+	return fallback
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %s", name, v, fallback)
+		return fallback
+	}
+	return d
+}
+
+func envInt(name string, fallback int) int {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %d", name, v, fallback)
+		return fallback
+	}
+	return n
+}
+
+func envBool(name string, fallback bool) bool {
+	v := os.Getenv(name)
+	if v == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		log.Printf("invalid %s %q, using %t", name, v, fallback)
+		return fallback
+	}
+	return b
 }
@@ -0,0 +1,96 @@
+package archive
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"net/http"
+	"time"
+)
+
+type Mode string
+
+const (
+	// ModeHTML keeps the page as HTML with its stylesheets and images
+	// inlined, so it renders without the original site.
+	ModeHTML Mode = "html"
+	// ModeText keeps only the readable text of the page.
+	ModeText Mode = "text"
+)
+
+// DefaultTimeout is how long capturing a page may take if an Archiver has
+// no Timeout of its own.
+const DefaultTimeout = 2 * time.Minute
+
+// Archiver turns fetched pages into self-contained copies and stores them.
+type Archiver struct {
+	Store Store
+	// Client fetches the stylesheets and images a page refers to.
+	Client *http.Client
+	Mode   Mode
+	// Timeout is how long capturing and storing one page may take,
+	// resources included. Zero means DefaultTimeout.
+	Timeout time.Duration
+}
+
+// Capture is an archived copy ready to be stored.
+type Capture struct {
+	Key         string
+	ContentType string
+	Data        []byte
+	// Partial is set if stylesheets or images were left out because the
+	// capture ran out of time or budget.
+	Partial bool
+}
+
+// CaptureTimeout is Timeout, or DefaultTimeout if it is not set.
+func (a *Archiver) CaptureTimeout() time.Duration {
+	if a.Timeout > 0 {
+		return a.Timeout
+	}
+	return DefaultTimeout
+}
+
+// Capture builds an archived copy of the page at pageURL from its body.
+// text is the page's readable text, used in text mode and for pages that
+// aren't HTML.
+func (a *Archiver) Capture(ctx context.Context, pageURL, contentType string, body []byte, text string) (Capture, error) {
+	var c Capture
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	if a.Mode != ModeText && (mediaType == "text/html" || mediaType == "application/xhtml+xml") {
+		inlined, partial, err := Inline(ctx, a.Client, pageURL, contentType, body)
+		if err != nil {
+			return c, err
+		}
+		c.ContentType = "text/html; charset=utf-8"
+		c.Data = inlined
+		c.Partial = partial
+	} else {
+		c.ContentType = "text/plain; charset=utf-8"
+		c.Data = []byte(text)
+	}
+
+	hash := sha256.Sum256(c.Data)
+	c.Key = hex.EncodeToString(hash[:])
+	return c, nil
+}
+
+func (a *Archiver) Save(ctx context.Context, c Capture) error {
+	return a.Store.Put(ctx, c.Key, c.Data)
+}
+
+func (a *Archiver) Load(ctx context.Context, key string) ([]byte, error) {
+	return a.Store.Get(ctx, key)
+}
+
+// ServeArchive writes an archived copy with headers that keep it from
+// running scripts or loading anything from the network.
+func ServeArchive(w http.ResponseWriter, contentType string, data []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Security-Policy",
+		"sandbox; default-src 'none'; img-src data:; style-src 'unsafe-inline' data:; font-src data:")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write(data)
+}
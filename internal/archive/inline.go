@@ -0,0 +1,309 @@
+package archive
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+const (
+	maxResource = 2 << 20  // largest single stylesheet or image inlined
+	maxTotal    = 16 << 20 // budget for all resources of one page
+)
+
+// Elements that run code or pull in other documents and have no place in
+// a static copy.
+var droppedElements = map[atom.Atom]bool{
+	atom.Script:   true,
+	atom.Noscript: true,
+	atom.Iframe:   true,
+	atom.Frame:    true,
+	atom.Object:   true,
+	atom.Embed:    true,
+	atom.Applet:   true,
+	atom.Base:     true,
+	atom.Source:   true,
+}
+
+var (
+	cssURL    = regexp.MustCompile(`url\(\s*(['"]?)([^'")]+)(['"]?)\s*\)`)
+	cssImport = regexp.MustCompile(`@import\s+(?:url\(\s*)?['"]?([^'")\s;]+)['"]?\s*\)?[^;]*;`)
+)
+
+// inliner fetches the resources of one page, sharing a byte budget and a
+// cache so an image used twice is only fetched once.
+type inliner struct {
+	ctx    context.Context
+	client *http.Client
+	budget int
+	cache  map[string]string
+	// partial is set once a resource is left out for want of time or
+	// budget rather than because the site failed to serve it.
+	partial bool
+}
+
+// Inline rewrites an HTML page into a self-contained document: scripts and
+// frames are removed, stylesheets and images become inline data, and links
+// are made absolute so they still lead to the live site. It reports the
+// copy as partial if resources were left out because ctx ran out or the
+// page's resources were too large in total.
+func Inline(ctx context.Context, client *http.Client, pageURL, contentType string, body []byte) (data []byte, partial bool, err error) {
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return nil, false, err
+	}
+
+	r, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return nil, false, err
+	}
+	doc, err := html.Parse(r)
+	if err != nil {
+		return nil, false, err
+	}
+
+	in := &inliner{ctx: ctx, client: client, budget: maxTotal, cache: make(map[string]string)}
+	in.rewrite(doc, base)
+	setCharset(doc)
+
+	var out bytes.Buffer
+	if err := html.Render(&out, doc); err != nil {
+		return nil, false, err
+	}
+	return out.Bytes(), in.partial, nil
+}
+
+func (in *inliner) rewrite(n *html.Node, base *url.URL) {
+	for c := n.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.Type == html.ElementNode && in.drop(c) {
+			n.RemoveChild(c)
+		} else {
+			if c.Type == html.ElementNode {
+				in.rewriteElement(c, base)
+			}
+			in.rewrite(c, base)
+		}
+		c = next
+	}
+}
+
+func (in *inliner) drop(n *html.Node) bool {
+	if droppedElements[n.DataAtom] {
+		return true
+	}
+	switch n.DataAtom {
+	case atom.Meta:
+		return strings.EqualFold(attr(n, "http-equiv"), "refresh")
+	case atom.Link:
+		return !isStylesheet(n)
+	}
+	return false
+}
+
+func (in *inliner) rewriteElement(n *html.Node, base *url.URL) {
+	// Event handlers and subresource hints don't survive archiving.
+	attrs := n.Attr[:0]
+	for _, a := range n.Attr {
+		key := strings.ToLower(a.Key)
+		if strings.HasPrefix(key, "on") || key == "srcset" || key == "integrity" ||
+			key == "nonce" || key == "crossorigin" {
+			continue
+		}
+		if (key == "href" || key == "action") &&
+			strings.HasPrefix(strings.ToLower(strings.TrimSpace(a.Val)), "javascript:") {
+			continue
+		}
+		attrs = append(attrs, a)
+	}
+	n.Attr = attrs
+
+	switch n.DataAtom {
+	case atom.Link:
+		href := resolve(base, attr(n, "href"))
+		n.Data, n.DataAtom, n.Attr = "style", atom.Style, nil
+		if css, ok := in.fetchText(href); ok {
+			u, _ := url.Parse(href)
+			n.AppendChild(&html.Node{Type: html.TextNode, Data: in.inlineCSS(css, u, 0)})
+		}
+	case atom.Style:
+		if t := n.FirstChild; t != nil && t.Type == html.TextNode {
+			t.Data = in.inlineCSS(t.Data, base, 0)
+		}
+	case atom.Img, atom.Input, atom.Video:
+		for i, a := range n.Attr {
+			if a.Key == "src" || a.Key == "poster" {
+				n.Attr[i].Val = in.dataURI(resolve(base, a.Val))
+			}
+		}
+	case atom.A, atom.Area, atom.Form:
+		for i, a := range n.Attr {
+			if a.Key == "href" || a.Key == "action" {
+				n.Attr[i].Val = resolve(base, a.Val)
+			}
+		}
+	}
+
+	for i, a := range n.Attr {
+		if a.Key == "style" {
+			n.Attr[i].Val = in.inlineCSS(a.Val, base, 0)
+		}
+	}
+}
+
+// inlineCSS replaces url() references in css with data URIs and @import
+// rules with the imported stylesheet.
+func (in *inliner) inlineCSS(css string, base *url.URL, depth int) string {
+	css = cssImport.ReplaceAllStringFunc(css, func(rule string) string {
+		if depth >= 3 {
+			return ""
+		}
+		ref := resolve(base, cssImport.FindStringSubmatch(rule)[1])
+		imported, ok := in.fetchText(ref)
+		if !ok {
+			return ""
+		}
+		u, _ := url.Parse(ref)
+		return in.inlineCSS(imported, u, depth+1)
+	})
+	return cssURL.ReplaceAllStringFunc(css, func(ref string) string {
+		target := cssURL.FindStringSubmatch(ref)[2]
+		if strings.HasPrefix(target, "data:") || strings.HasPrefix(target, "#") {
+			return ref
+		}
+		return `url("` + in.dataURI(resolve(base, target)) + `")`
+	})
+}
+
+func (in *inliner) fetchText(ref string) (string, bool) {
+	data, _, ok := in.fetch(ref)
+	return string(data), ok
+}
+
+// dataURI returns ref as a data URI, or an empty data URI if it can't be
+// fetched, so the archived page never reaches out to the network.
+func (in *inliner) dataURI(ref string) string {
+	if strings.HasPrefix(ref, "data:") {
+		return ref
+	}
+	if uri, ok := in.cache[ref]; ok {
+		return uri
+	}
+
+	uri := "data:,"
+	if data, contentType, ok := in.fetch(ref); ok {
+		uri = "data:" + contentType + ";base64," + base64.StdEncoding.EncodeToString(data)
+	}
+	in.cache[ref] = uri
+	return uri
+}
+
+func (in *inliner) fetch(ref string) ([]byte, string, bool) {
+	u, err := url.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, "", false
+	}
+	if in.budget <= 0 || in.ctx.Err() != nil {
+		in.partial = true
+		return nil, "", false
+	}
+
+	req, err := http.NewRequestWithContext(in.ctx, http.MethodGet, ref, nil)
+	if err != nil {
+		return nil, "", false
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		if in.ctx.Err() != nil {
+			in.partial = true
+		}
+		return nil, "", false
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", false
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, min(maxResource, int64(in.budget))+1))
+	if err != nil || len(data) > min(maxResource, in.budget) {
+		if in.ctx.Err() != nil || len(data) > in.budget {
+			in.partial = true
+		}
+		return nil, "", false
+	}
+	in.budget -= len(data)
+
+	contentType, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if contentType == "" {
+		contentType, _, _ = mime.ParseMediaType(http.DetectContentType(data))
+	}
+	return data, contentType, true
+}
+
+func resolve(base *url.URL, ref string) string {
+	u, err := base.Parse(strings.TrimSpace(ref))
+	if err != nil {
+		return ref
+	}
+	return u.String()
+}
+
+func isStylesheet(n *html.Node) bool {
+	for _, rel := range strings.Fields(attr(n, "rel")) {
+		if strings.EqualFold(rel, "stylesheet") {
+			return true
+		}
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+// setCharset replaces any charset declaration with UTF-8, which is what
+// the document was decoded into.
+func setCharset(doc *html.Node) {
+	head := find(doc, atom.Head)
+	if head == nil {
+		return
+	}
+	for c := head.FirstChild; c != nil; {
+		next := c.NextSibling
+		if c.DataAtom == atom.Meta && (attr(c, "charset") != "" ||
+			strings.EqualFold(attr(c, "http-equiv"), "content-type")) {
+			head.RemoveChild(c)
+		}
+		c = next
+	}
+	meta := &html.Node{Type: html.ElementNode, Data: "meta", DataAtom: atom.Meta,
+		Attr: []html.Attribute{{Key: "charset", Val: "utf-8"}}}
+	head.InsertBefore(meta, head.FirstChild)
+}
+
+func find(n *html.Node, a atom.Atom) *html.Node {
+	if n.Type == html.ElementNode && n.DataAtom == a {
+		return n
+	}
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if found := find(c, a); found != nil {
+			return found
+		}
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package archive
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestInline(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/style.css", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/css")
+		w.Write([]byte("body { background: url(bg.png) }"))
+	})
+	mux.HandleFunc("/bg.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write([]byte("png"))
+	})
+	mux.HandleFunc("/slow.png", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	page := func(img string) []byte {
+		return []byte(`<html><head><link rel="stylesheet" href="/style.css"><script>alert(1)</script></head>
+<body><img src="` + img + `" onerror="alert(2)"><a href="/next">next</a></body></html>`)
+	}
+
+	tests := []struct {
+		name    string
+		img     string
+		timeout time.Duration
+		partial bool
+	}{
+		{"complete", "/bg.png", 5 * time.Second, false},
+		{"missing resource", "/gone.png", 5 * time.Second, false},
+		{"out of time", "/slow.png", 200 * time.Millisecond, true},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), tt.timeout)
+		data, partial, err := Inline(ctx, srv.Client(), srv.URL+"/page", "text/html", page(tt.img))
+		cancel()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if partial != tt.partial {
+			t.Errorf("%s: partial = %t, want %t", tt.name, partial, tt.partial)
+		}
+
+		out := string(data)
+		if strings.Contains(out, "alert") {
+			t.Errorf("%s: scripts survived: %s", tt.name, out)
+		}
+		if !strings.Contains(out, "data:image/png;base64,") {
+			t.Errorf("%s: stylesheet image not inlined: %s", tt.name, out)
+		}
+		if !strings.Contains(out, `href="`+srv.URL+`/next"`) {
+			t.Errorf("%s: link not made absolute: %s", tt.name, out)
+		}
+	}
+}
+
+func TestInlineStopsAtDeadline(t *testing.T) {
+	var hits int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+		w.Write([]byte("img"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, partial, err := Inline(ctx, srv.Client(), srv.URL, "text/html",
+		[]byte(`<img src="/a.png"><img src="/b.png">`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !partial || hits != 0 {
+		t.Errorf("after the deadline: partial = %t with %d fetches, want partial with none", partial, hits)
+	}
+}
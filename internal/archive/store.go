@@ -0,0 +1,160 @@
+package archive
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNotFound = errors.New("archive not found")
+
+// Store keeps archived pages under content-derived keys. Putting the same
+// key twice is harmless, and counts as storing it anew.
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Keys lists the keys last stored before the given time.
+	Keys(ctx context.Context, before time.Time) ([]string, error)
+	// Delete removes key unless it has been stored again since before, and
+	// reports whether it did.
+	Delete(ctx context.Context, key string, before time.Time) (bool, error)
+}
+
+// DirStore keeps archives as files below Dir.
+type DirStore struct {
+	Dir string
+}
+
+func (s DirStore) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key)
+}
+
+func (s DirStore) Put(ctx context.Context, key string, data []byte) error {
+	path := s.path(key)
+	if _, err := os.Stat(path); err == nil {
+		now := time.Now()
+		return os.Chtimes(path, now, now)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated
+	// archive under the final name.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s DirStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s DirStore) Keys(ctx context.Context, before time.Time) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(s.Dir, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == s.Dir {
+			return fs.SkipAll
+		}
+		if err != nil || d.IsDir() || strings.HasPrefix(d.Name(), ".tmp-") {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.ModTime().Before(before) {
+			keys = append(keys, d.Name())
+		}
+		return nil
+	})
+	return keys, err
+}
+
+func (s DirStore) Delete(ctx context.Context, key string, before time.Time) (bool, error) {
+	path := s.path(key)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil || !info.ModTime().Before(before) {
+		return false, err
+	}
+	return true, os.Remove(path)
+}
+
+// PostgresStore keeps archives in the archive_blobs table.
+type PostgresStore struct {
+	Pool *pgxpool.Pool
+}
+
+func (s PostgresStore) Put(ctx context.Context, key string, data []byte) error {
+	_, err := s.Pool.Exec(ctx,
+		`INSERT INTO archive_blobs (key, data) VALUES ($1, $2)
+         ON CONFLICT (key) DO UPDATE SET stored_at = now()`,
+		key, data,
+	)
+	return err
+}
+
+func (s PostgresStore) Get(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	err := s.Pool.QueryRow(ctx,
+		`SELECT data FROM archive_blobs WHERE key = $1`,
+		key,
+	).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (s PostgresStore) Keys(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := s.Pool.Query(ctx,
+		`SELECT key FROM archive_blobs WHERE stored_at < $1`,
+		before,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (s PostgresStore) Delete(ctx context.Context, key string, before time.Time) (bool, error) {
+	tag, err := s.Pool.Exec(ctx,
+		`DELETE FROM archive_blobs WHERE key = $1 AND stored_at < $2`,
+		key, before,
+	)
+	return tag.RowsAffected() > 0, err
+}
@@ -0,0 +1,257 @@
+// Package attachments stores files attached to entries. Contents are kept
+// once per distinct SHA-256, however many entries they are attached to.
+package attachments
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/entries"
+)
+
+type Attachment struct {
+	ID          string
+	EntryID     string
+	CreatedAt   time.Time
+	Filename    string
+	ContentType string
+	Size        int64
+	SHA256      string
+}
+
+// inlineTypes are the content types served for display in the page rather
+// than as downloads. Anything else could run script on this origin.
+var inlineTypes = map[string]bool{
+	"image/png":       true,
+	"image/jpeg":      true,
+	"image/gif":       true,
+	"image/webp":      true,
+	"application/pdf": true,
+}
+
+// Inline reports whether the attachment can be shown in the browser.
+func (a Attachment) Inline() bool {
+	return inlineTypes[a.ContentType]
+}
+
+func (a Attachment) IsImage() bool {
+	return a.Inline() && strings.HasPrefix(a.ContentType, "image/")
+}
+
+// SizeLabel is the size rounded for display.
+func (a Attachment) SizeLabel() string {
+	switch {
+	case a.Size < 1<<10:
+		return fmt.Sprintf("%d bytes", a.Size)
+	case a.Size < 1<<20:
+		return fmt.Sprintf("%.1f KB", float64(a.Size)/(1<<10))
+	default:
+		return fmt.Sprintf("%.1f MB", float64(a.Size)/(1<<20))
+	}
+}
+
+// Add stores f and attaches it to an entry. The content type is sniffed
+// from the first bytes of f, falling back to the file name's extension
+// when sniffing finds nothing more specific than binary data.
+func Add(ctx context.Context, pool *pgxpool.Pool, store Store, entryID, filename string, f io.ReadSeeker) (Attachment, error) {
+	a := Attachment{EntryID: entryID, Filename: cleanFilename(filename)}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return a, err
+	}
+	a.ContentType, _, _ = strings.Cut(http.DetectContentType(head[:n]), ";")
+	if a.ContentType == "application/octet-stream" {
+		if t, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(a.Filename))); err == nil {
+			a.ContentType = t
+		}
+	}
+
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return a, err
+	}
+	hash := sha256.New()
+	if a.Size, err = io.Copy(hash, f); err != nil {
+		return a, err
+	}
+	a.SHA256 = hex.EncodeToString(hash.Sum(nil))
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return a, err
+	}
+
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return a, err
+	}
+	defer tx.Rollback(ctx)
+
+	// Claim the blob row before storing the file, so Sweep can't remove the
+	// file between storing it and committing.
+	_, err = tx.Exec(ctx,
+		`INSERT INTO attachment_blobs (sha256, size) VALUES ($1, $2)
+         ON CONFLICT (sha256) DO UPDATE SET size = EXCLUDED.size`,
+		a.SHA256, a.Size,
+	)
+	if err != nil {
+		return a, err
+	}
+	if err := store.Put(ctx, a.SHA256, f); err != nil {
+		return a, err
+	}
+
+	err = tx.QueryRow(ctx,
+		`INSERT INTO attachments (entry_id, filename, content_type, size, sha256)
+         VALUES ($1, $2, $3, $4, $5)
+         RETURNING id, created_at`,
+		a.EntryID, a.Filename, a.ContentType, a.Size, a.SHA256,
+	).Scan(&a.ID, &a.CreatedAt)
+	if err != nil {
+		return a, err
+	}
+	return a, tx.Commit(ctx)
+}
+
+func cleanFilename(name string) string {
+	// Browsers used to send the full client path.
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	name = strings.TrimSpace(name)
+	if name == "" || name == "." || name == ".." {
+		return "attachment"
+	}
+	return name
+}
+
+func List(ctx context.Context, pool *pgxpool.Pool, entryID string) ([]Attachment, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT id, entry_id, created_at, filename, content_type, size, sha256
+         FROM attachments
+         WHERE entry_id = $1
+         ORDER BY created_at`,
+		entryID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Attachment
+	for rows.Next() {
+		var a Attachment
+		err := rows.Scan(&a.ID, &a.EntryID, &a.CreatedAt, &a.Filename, &a.ContentType, &a.Size, &a.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+func Get(ctx context.Context, pool *pgxpool.Pool, id string) (Attachment, error) {
+	var a Attachment
+	err := pool.QueryRow(ctx,
+		`SELECT id, entry_id, created_at, filename, content_type, size, sha256
+         FROM attachments
+         WHERE id = $1`,
+		id,
+	).Scan(&a.ID, &a.EntryID, &a.CreatedAt, &a.Filename, &a.ContentType, &a.Size, &a.SHA256)
+	return a, err
+}
+
+// Delete removes an attachment, and its file unless another attachment has
+// the same content. It returns the entry the attachment belonged to.
+func Delete(ctx context.Context, pool *pgxpool.Pool, store Store, id string) (entries.Entry, error) {
+	var e entries.Entry
+	var key string
+	err := pool.QueryRow(ctx,
+		`DELETE FROM attachments a
+         USING entries e
+         WHERE a.id = $1 AND e.id = a.entry_id
+         RETURNING e.id, e.entry_type, e.title, a.sha256`,
+		id,
+	).Scan(&e.ID, &e.EntryType, &e.Title, &key)
+	if err != nil {
+		return e, err
+	}
+	_, err = removeBlob(ctx, pool, store, key)
+	return e, err
+}
+
+// Sweep removes the files no attachment refers to any more, such as those
+// of deleted entries. It returns how many were removed.
+func Sweep(ctx context.Context, pool *pgxpool.Pool, store Store) (int, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT b.sha256
+         FROM attachment_blobs b
+         WHERE NOT EXISTS (SELECT 1 FROM attachments a WHERE a.sha256 = b.sha256)`)
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, key := range keys {
+		ok, err := removeBlob(ctx, pool, store, key)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+// removeBlob deletes a file if nothing refers to it, and reports whether it
+// did. The row stays locked until the file is gone, so an upload of the same
+// content waits and then stores it afresh.
+func removeBlob(ctx context.Context, pool *pgxpool.Pool, store Store, key string) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx,
+		`DELETE FROM attachment_blobs b
+         WHERE b.sha256 = $1
+           AND NOT EXISTS (SELECT 1 FROM attachments a WHERE a.sha256 = b.sha256)`,
+		key,
+	)
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23503" {
+		// Attached again since we looked.
+		return false, nil
+	}
+	if err != nil || tag.RowsAffected() == 0 {
+		return false, err
+	}
+
+	if err := store.Delete(ctx, key); err != nil {
+		return false, err
+	}
+	return true, tx.Commit(ctx)
+}
@@ -0,0 +1,115 @@
+package attachments
+
+import (
+	"errors"
+	"log"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/entries"
+)
+
+// RegisterRoutes adds upload, download and delete routes for attachments
+// of any entry. Uploads larger than maxSize bytes are refused.
+func RegisterRoutes(mux *http.ServeMux, pool *pgxpool.Pool, store Store, maxSize int64) {
+	mux.HandleFunc("POST /entries/{id}/attachments", handleUpload(pool, store, maxSize))
+	mux.HandleFunc("GET /attachments/{id}", handleDownload(pool, store, false))
+	mux.HandleFunc("GET /attachments/{id}/inline", handleDownload(pool, store, true))
+	mux.HandleFunc("POST /attachments/{id}/delete", handleDelete(pool, store))
+}
+
+func handleUpload(pool *pgxpool.Pool, store Store, maxSize int64) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var e entries.Entry
+		err := pool.QueryRow(r.Context(),
+			`SELECT id, entry_type, title FROM entries WHERE id = $1`,
+			r.PathValue("id"),
+		).Scan(&e.ID, &e.EntryType, &e.Title)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		// Leave room for the rest of the multipart body.
+		r.Body = http.MaxBytesReader(w, r.Body, maxSize+1<<20)
+		file, header, err := r.FormFile("file")
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, "file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if err != nil {
+			http.Error(w, "file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+		defer r.MultipartForm.RemoveAll()
+
+		if header.Size > maxSize {
+			http.Error(w, "file is too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if _, err := Add(r.Context(), pool, store, e.ID, header.Filename, file); err != nil {
+			log.Println("attachment upload error:", err)
+			http.Error(w, "could not store file", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, e.Path()+"#attachments", http.StatusSeeOther)
+	}
+}
+
+// handleDownload serves an attachment as a download, or for display in the
+// page if inline is set and its type is safe to show.
+func handleDownload(pool *pgxpool.Pool, store Store, inline bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		a, err := Get(r.Context(), pool, r.PathValue("id"))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		f, err := store.Open(r.Context(), a.SHA256)
+		if errors.Is(err, ErrNotFound) {
+			http.Error(w, "file missing", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "storage error", http.StatusInternalServerError)
+			return
+		}
+		defer f.Close()
+
+		disposition := "attachment"
+		if inline && a.Inline() {
+			disposition = "inline"
+		}
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": a.Filename}))
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		// Contents never change under an attachment's ID.
+		w.Header().Set("ETag", strconv.Quote(a.SHA256))
+		w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+		http.ServeContent(w, r, "", a.CreatedAt, f)
+	}
+}
+
+func handleDelete(pool *pgxpool.Pool, store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		e, err := Delete(r.Context(), pool, store, r.PathValue("id"))
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, e.Path()+"#attachments", http.StatusSeeOther)
+	}
+}
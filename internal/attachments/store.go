@@ -0,0 +1,192 @@
+package attachments
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var ErrNotFound = errors.New("attachment not found")
+
+// Store keeps file contents under content-derived keys. Putting the same
+// key twice is harmless, and so is deleting a key that isn't there.
+type Store interface {
+	Put(ctx context.Context, key string, r io.Reader) error
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// DirStore keeps files below Dir.
+type DirStore struct {
+	Dir string
+}
+
+func (s DirStore) path(key string) string {
+	return filepath.Join(s.Dir, key[:2], key)
+}
+
+func (s DirStore) Put(ctx context.Context, key string, r io.Reader) error {
+	path := s.path(key)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated
+	// file under the final name.
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s DirStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	f, err := os.Open(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (s DirStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// LargeObjectStore keeps files as Postgres large objects, found through the
+// attachment_objects table.
+type LargeObjectStore struct {
+	Pool *pgxpool.Pool
+}
+
+func (s LargeObjectStore) Put(ctx context.Context, key string, r io.Reader) error {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	err = tx.QueryRow(ctx,
+		`SELECT EXISTS (SELECT 1 FROM attachment_objects WHERE key = $1)`,
+		key,
+	).Scan(&exists)
+	if err != nil || exists {
+		return err
+	}
+
+	objects := tx.LargeObjects()
+	oid, err := objects.Create(ctx, 0)
+	if err != nil {
+		return err
+	}
+	obj, err := objects.Open(ctx, oid, pgx.LargeObjectModeWrite)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(obj, r); err != nil {
+		return err
+	}
+	if err := obj.Close(); err != nil {
+		return err
+	}
+
+	// If the same file was stored meanwhile, rolling back drops this copy.
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO attachment_objects (key, oid) VALUES ($1, $2)
+         ON CONFLICT (key) DO NOTHING`,
+		key, oid,
+	)
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Open reads the object in a transaction of its own, which ends when the
+// returned file is closed.
+func (s LargeObjectStore) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var oid uint32
+	err = tx.QueryRow(ctx,
+		`SELECT oid FROM attachment_objects WHERE key = $1`,
+		key,
+	).Scan(&oid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		tx.Rollback(ctx)
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+
+	objects := tx.LargeObjects()
+	obj, err := objects.Open(ctx, oid, pgx.LargeObjectModeRead)
+	if err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return &largeObjectFile{LargeObject: obj, ctx: ctx, tx: tx}, nil
+}
+
+func (s LargeObjectStore) Delete(ctx context.Context, key string) error {
+	tx, err := s.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var oid uint32
+	err = tx.QueryRow(ctx,
+		`DELETE FROM attachment_objects WHERE key = $1 RETURNING oid`,
+		key,
+	).Scan(&oid)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	objects := tx.LargeObjects()
+	if err := objects.Unlink(ctx, oid); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+type largeObjectFile struct {
+	*pgx.LargeObject
+	ctx context.Context
+	tx  pgx.Tx
+}
+
+func (f *largeObjectFile) Close() error {
+	err := f.LargeObject.Close()
+	f.tx.Rollback(f.ctx)
+	return err
+}
@@ -0,0 +1,48 @@
+package bookmarks
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"time"
+)
+
+// CertInfo describes the certificate a site presented.
+type CertInfo struct {
+	Issuer   string
+	Subject  string
+	SANs     []string
+	NotAfter time.Time
+}
+
+func certInfo(cert *x509.Certificate) *CertInfo {
+	info := &CertInfo{
+		Issuer:   cert.Issuer.String(),
+		Subject:  cert.Subject.String(),
+		NotAfter: cert.NotAfter,
+	}
+	info.SANs = append(info.SANs, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		info.SANs = append(info.SANs, ip.String())
+	}
+	return info
+}
+
+// certFromState returns the leaf certificate of a TLS connection, or nil
+// for plain HTTP.
+func certFromState(state *tls.ConnectionState) *CertInfo {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return nil
+	}
+	return certInfo(state.PeerCertificates[0])
+}
+
+// certFromError recovers the certificate a site presented when it failed
+// verification, which is when it matters most.
+func certFromError(err error) *CertInfo {
+	var certErr *tls.CertificateVerificationError
+	if !errors.As(err, &certErr) || len(certErr.UnverifiedCertificates) == 0 {
+		return nil
+	}
+	return certInfo(certErr.UnverifiedCertificates[0])
+}
@@ -2,40 +2,389 @@ package bookmarks
 
 import (
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"io"
+	"log"
 	"net/http"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/archive"
 )
 
-func Check(ctx context.Context, pool *pgxpool.Pool, entryID string) error {
-	var url string
-	err := pool.QueryRow(ctx,
-		`SELECT url FROM bookmarks WHERE entry_id = $1`,
-		entryID,
-	).Scan(&url)
+// Options controls how a Checker treats the sites it visits.
+type Options struct {
+	// UserAgent is sent with every request; DefaultUserAgent if empty.
+	UserAgent string
+	// Hosts limits how hard any single host is hit. It is shared by all
+	// checks; nil means no limit.
+	Hosts *HostLimiter
+	// Robots, if set, makes checks skip URLs that robots.txt disallows.
+	Robots *RobotsCache
+	// HeadFirst tries a HEAD request before downloading a page that has
+	// sent validators before, and only falls back to GET when HEAD fails
+	// or the validators show the page may have changed.
+	HeadFirst bool
+}
+
+func (o Options) userAgent() string {
+	if o.UserAgent == "" {
+		return DefaultUserAgent
+	}
+	return o.UserAgent
+}
+
+// Client returns an HTTP client that sends requests through base with the
+// User-Agent, robots.txt and per-host limits of o applied.
+func (o Options) Client(base http.RoundTripper, timeout time.Duration) *http.Client {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &politeTransport{base: base, opts: o},
+	}
+}
+
+// Redirect is one hop of a redirect chain.
+type Redirect struct {
+	URL      string `json:"url"`
+	Status   int    `json:"status"`
+	Location string `json:"location"`
+}
+
+type CheckResult struct {
+	CheckedAt   time.Time
+	Status      int
+	Latency     time.Duration
+	FinalURL    string
+	ContentHash *string
+	ErrorClass  FailureClass
+	Redirects   []Redirect
+	// ETag and LastModified are the validators of a successful final
+	// response, sent back on the next check to make it conditional.
+	ETag         string
+	LastModified string
+	// Soft404 is the confidence, from 0 to 1, that a successful response is
+	// really a "not found" page.
+	Soft404 float64
+	// Snapshot is the normalized text the content hash was taken over, or
+	// nil when the response had no readable text.
+	Snapshot *string
+	// Body and ContentType are what a successful GET returned, for sinks
+	// that keep more than the hash.
+	Body        []byte
+	ContentType string
+	// Metadata is what a successful HTML page says about itself.
+	Metadata *PageMetadata
+	// Cert is the certificate the final host presented, even if it failed
+	// verification; nil for plain HTTP or when no TLS handshake got that far.
+	Cert *CertInfo
+}
+
+func (r CheckResult) Healthy() bool {
+	return r.ErrorClass == FailureNone && r.Status >= 200 && r.Status < 400
+}
+
+// Inconclusive reports whether the check says nothing about the bookmark's
+// health, because the host asked us to back off or robots.txt kept us out.
+func (r CheckResult) Inconclusive() bool {
+	return r.ErrorClass.Inconclusive()
+}
+
+// soft404 is the confidence to store for the check: nil when the response
+// had no readable body to judge.
+func (r CheckResult) soft404() *float64 {
+	if r.Snapshot == nil {
+		return nil
+	}
+	return &r.Soft404
+}
+
+// PermanentTarget returns where the bookmark has moved to for good: the
+// final URL of a redirect chain made only of 301 and 308 hops that ends in a
+// successful or not-modified response. It returns "" for any other result,
+// including a chain that ends on a soft 404 such as the site's home page.
+func (r CheckResult) PermanentTarget() string {
+	ok := r.Status >= 200 && r.Status < 300 || r.Status == http.StatusNotModified
+	if len(r.Redirects) == 0 || !ok || r.ErrorClass != FailureNone {
+		return ""
+	}
+	for _, hop := range r.Redirects {
+		if hop.Status != http.StatusMovedPermanently && hop.Status != http.StatusPermanentRedirect {
+			return ""
+		}
+	}
+	return r.FinalURL
+}
+
+// Clock is the time source a Checker measures and waits with.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// ResultSink receives the outcome of every check a Checker makes.
+type ResultSink interface {
+	Record(ctx context.Context, b Bookmark, result CheckResult) error
+}
+
+// PoolSink stores check results in the database.
+type PoolSink struct {
+	Pool *pgxpool.Pool
+	// AutoRewrite replaces a bookmark's URL with the target of a permanent
+	// redirect chain instead of only suggesting it.
+	AutoRewrite bool
+	// Archiver, if set, keeps a copy of the page the first time it is
+	// fetched and whenever its content changes.
+	Archiver *archive.Archiver
+}
+
+func (s PoolSink) Record(ctx context.Context, b Bookmark, result CheckResult) error {
+	if err := UpdateCheckResult(ctx, s.Pool, b.EntryID, result); err != nil {
+		return err
+	}
+	// Soft 404s and other failures are not worth keeping a copy of.
+	if s.Archiver != nil && result.Healthy() && result.Body != nil && result.ContentHash != nil {
+		// A failed archive shouldn't fail the check that was just recorded.
+		if err := s.archive(ctx, b, result); err != nil {
+			log.Printf("archive %s: %v", b.EntryID, err)
+		}
+	}
+	if s.AutoRewrite && result.PermanentTarget() != "" {
+		return ApplySuggestedURL(ctx, s.Pool, b.EntryID)
+	}
+	return nil
+}
+
+func (s PoolSink) archive(ctx context.Context, b Bookmark, result CheckResult) error {
+	// Archiving gets a deadline of its own: what is left of the check's
+	// is too little for a page with many stylesheets and images.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.Archiver.CaptureTimeout())
+	defer cancel()
+
+	exists, err := HasArchive(ctx, s.Pool, b.EntryID, *result.ContentHash)
+	if err != nil || exists {
+		return err
+	}
+
+	var text string
+	if result.Snapshot != nil {
+		text = *result.Snapshot
+	}
+	capture, err := s.Archiver.Capture(ctx, result.FinalURL, result.ContentType, result.Body, text)
 	if err != nil {
 		return err
 	}
+	if err := s.Archiver.Save(ctx, capture); err != nil {
+		return err
+	}
+	return AddArchive(ctx, s.Pool, b.EntryID, *result.ContentHash, capture)
+}
 
-	client := &http.Client{Timeout: 10 * time.Second}
-	resp, err := client.Get(url)
+// Checker fetches bookmarks and hands the results to Sink. The zero value
+// of every field other than Sink has a usable default.
+type Checker struct {
+	Options
+	Transport http.RoundTripper
+	// Clock times checks and the pauses between retries. Options.Hosts and
+	// Options.Robots have clocks of their own.
+	Clock Clock
+	Sink  ResultSink
+	// Timeout bounds a single attempt, including reading the body.
+	Timeout time.Duration
+	// MaxBody is how much of a response body is read and hashed.
+	MaxBody int64
 
-	var status int
-	var contentHash *string
+	// CertWarning is how long before its certificate expires that a
+	// bookmark is reported as expiring soon.
+	CertWarning time.Duration
+
+	probes soft404Probes
+}
+
+func NewChecker(sink ResultSink, opts Options) *Checker {
+	return &Checker{
+		Options:     opts,
+		Sink:        sink,
+		Timeout:     10 * time.Second,
+		MaxBody:     1 << 20,
+		CertWarning: 14 * 24 * time.Hour,
+	}
+}
+
+func (c *Checker) clock() Clock {
+	if c.Clock == nil {
+		return realClock{}
+	}
+	return c.Clock
+}
+
+func (c *Checker) client() *http.Client {
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+	return c.Options.Client(c.Transport, timeout)
+}
+
+// Check fetches b, retrying failures that may be transient, and records the
+// final attempt with the sink.
+func (c *Checker) Check(ctx context.Context, b Bookmark) (CheckResult, error) {
+	client := c.client()
+
+	var result CheckResult
+	for attempt := 1; ; attempt++ {
+		result = c.fetch(ctx, client, b)
+		if attempt >= result.ErrorClass.Attempts() {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return result, ctx.Err()
+		case <-c.clock().After(retryBackoff(attempt)):
+		}
+	}
+
+	return result, c.Sink.Record(ctx, b, result)
+}
+
+func (c *Checker) fetch(ctx context.Context, client *http.Client, b Bookmark) (result CheckResult) {
+	clock := c.clock()
+	result.CheckedAt = clock.Now()
+	defer func() { result.Latency = clock.Now().Sub(result.CheckedAt) }()
+
+	// HEAD only saves the download when it shows the page unchanged, which
+	// takes validators from an earlier fetch; a page that sent none would
+	// pay for HEAD and GET on every check.
+	if c.HeadFirst && (b.ETag != nil || b.LastModified != nil) {
+		resp, err := c.do(ctx, client, http.MethodHead, b, &result)
+		if err != nil {
+			result.ErrorClass = classifyError(err)
+			result.Cert = certFromError(err)
+			return result
+		}
+		resp.Body.Close()
+
+		// A HEAD answer is enough unless it is an error, which many servers
+		// and CDNs give for HEAD alone, or the page may have changed and
+		// needs its body hashed.
+		switch {
+		case resp.StatusCode >= 400:
+		case resp.StatusCode >= 200 && resp.StatusCode < 300 && !unchanged(resp, b):
+		default:
+			c.readResponse(resp, nil, &result)
+			return result
+		}
+		result.Redirects = nil
+	}
+
+	resp, err := c.do(ctx, client, http.MethodGet, b, &result)
 	if err != nil {
-		status = 0
-	} else {
-		defer resp.Body.Close()
-		status = resp.StatusCode
-		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
-		hash := sha256.Sum256(body)
-		hashStr := hex.EncodeToString(hash[:])
-		contentHash = &hashStr
+		result.ErrorClass = classifyError(err)
+		result.Cert = certFromError(err)
+		return result
 	}
+	defer resp.Body.Close()
 
-	return UpdateCheckResult(ctx, pool, entryID, status, contentHash)
+	maxBody := c.MaxBody
+	if maxBody == 0 {
+		maxBody = 1 << 20
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxBody))
+	if err != nil {
+		result.ErrorClass = classifyError(err)
+		return result
+	}
+	c.readResponse(resp, body, &result)
+
+	if result.Healthy() {
+		result.Soft404 = c.detectSoft404(ctx, client, b, result, body)
+		if result.Soft404 >= soft404Threshold {
+			result.ErrorClass = FailureSoft404
+		}
+	}
+	return result
+}
+
+// do sends a conditional request for b, recording each redirect hop in
+// result.
+func (c *Checker) do(ctx context.Context, client *http.Client, method string, b Bookmark, result *CheckResult) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	if b.ETag != nil {
+		req.Header.Set("If-None-Match", *b.ETag)
+	}
+	if b.LastModified != nil {
+		req.Header.Set("If-Modified-Since", *b.LastModified)
+	}
+
+	// Each request records its own redirect chain, so the client is copied
+	// rather than given a hook that every request would write to.
+	cl := *client
+	cl.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		result.Redirects = append(result.Redirects, Redirect{
+			URL:      via[len(via)-1].URL.String(),
+			Status:   req.Response.StatusCode,
+			Location: req.URL.String(),
+		})
+		if len(via) >= 10 {
+			return errTooManyRedirects
+		}
+		return nil
+	}
+	return cl.Do(req)
+}
+
+// readResponse fills result from resp. body is nil for HEAD responses.
+func (c *Checker) readResponse(resp *http.Response, body []byte, result *CheckResult) {
+	result.Status = resp.StatusCode
+	result.ErrorClass = classifyStatus(resp.StatusCode)
+	result.FinalURL = resp.Request.URL.String()
+	result.Cert = certFromState(resp.TLS)
+
+	// An error page's validators would make the next check ask whether the
+	// error page has changed.
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		result.ETag = resp.Header.Get("ETag")
+		result.LastModified = resp.Header.Get("Last-Modified")
+	}
+
+	// Only successful responses say anything about the page's content;
+	// hashing an error page would report drift once the page is back. A
+	// 304 or a HEAD answer leaves the previous hash in place.
+	if body != nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		result.Body = body
+		result.ContentType = resp.Header.Get("Content-Type")
+		if meta, ok := ExtractMetadata(result.FinalURL, result.ContentType, body); ok {
+			result.Metadata = &meta
+		}
+
+		var hash string
+		if text, ok := NormalizeContent(resp.Header.Get("Content-Type"), body); ok {
+			hash = HashContent(text)
+			result.Snapshot = &text
+		} else {
+			hash = HashContent(string(body))
+		}
+		result.ContentHash = &hash
+	}
+}
+
+// unchanged reports whether resp carries the same validators that were
+// stored for b on its last full fetch.
+func unchanged(resp *http.Response, b Bookmark) bool {
+	if etag := resp.Header.Get("ETag"); etag != "" && b.ETag != nil {
+		return etag == *b.ETag
+	}
+	if lm := resp.Header.Get("Last-Modified"); lm != "" && b.LastModified != nil {
+		return lm == *b.LastModified
+	}
+	return false
 }
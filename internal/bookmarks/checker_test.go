@@ -0,0 +1,461 @@
+package bookmarks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeClock moves only when it is waited on, so retries and backoffs take
+// no real time.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.Advance(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.Now()
+	return ch
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// recordingSink keeps every result it is given.
+type recordingSink struct {
+	mu      sync.Mutex
+	results []CheckResult
+}
+
+func (s *recordingSink) Record(ctx context.Context, b Bookmark, result CheckResult) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.results = append(s.results, result)
+	return nil
+}
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name       string
+		path       string
+		handler    http.HandlerFunc
+		wantStatus int
+		wantClass  FailureClass
+		wantHits   int32
+		check      func(t *testing.T, r CheckResult)
+	}{
+		{
+			name: "ok",
+			path: "/ok",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/html")
+				w.Header().Set("ETag", `"v1"`)
+				w.Write([]byte("<html><head><title>Hello</title></head><body><p>Some text</p></body></html>"))
+			},
+			wantStatus: http.StatusOK,
+			wantClass:  FailureNone,
+			wantHits:   1,
+			check: func(t *testing.T, r CheckResult) {
+				if r.ContentHash == nil {
+					t.Error("no content hash")
+				}
+				if r.ETag != `"v1"` {
+					t.Errorf("ETag = %q", r.ETag)
+				}
+				if r.Metadata == nil || r.Metadata.Title != "Hello" {
+					t.Errorf("metadata = %+v", r.Metadata)
+				}
+			},
+		},
+		{
+			name: "not found",
+			path: "/missing",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("ETag", `"error"`)
+				w.Header().Set("Last-Modified", "Sat, 17 Oct 2026 12:00:00 GMT")
+				http.NotFound(w, r)
+			},
+			wantStatus: http.StatusNotFound,
+			wantClass:  FailureClientError,
+			wantHits:   1,
+			check: func(t *testing.T, r CheckResult) {
+				if r.ContentHash != nil {
+					t.Error("error page was hashed")
+				}
+				if r.ETag != "" || r.LastModified != "" {
+					t.Errorf("error page validators kept: %q, %q", r.ETag, r.LastModified)
+				}
+			},
+		},
+		{
+			name: "server error is retried",
+			path: "/broken",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			},
+			wantStatus: http.StatusServiceUnavailable,
+			wantClass:  FailureServerError,
+			wantHits:   2,
+		},
+		{
+			name: "redirect loop",
+			path: "/loop",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/loop", http.StatusFound)
+			},
+			wantClass: FailureTooManyRedirects,
+			wantHits:  10,
+			check: func(t *testing.T, r CheckResult) {
+				if len(r.Redirects) != 10 {
+					t.Errorf("%d redirects recorded, want 10", len(r.Redirects))
+				}
+			},
+		},
+		{
+			name: "permanent redirect",
+			path: "/old",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/ok", http.StatusMovedPermanently)
+			},
+			wantStatus: http.StatusOK,
+			wantClass:  FailureNone,
+			wantHits:   1,
+			check: func(t *testing.T, r CheckResult) {
+				if !strings.HasSuffix(r.PermanentTarget(), "/ok") {
+					t.Errorf("PermanentTarget() = %q", r.PermanentTarget())
+				}
+			},
+		},
+		{
+			name: "slow body",
+			path: "/slow",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(http.StatusOK)
+				w.Write([]byte("start"))
+				w.(http.Flusher).Flush()
+				select {
+				case <-time.After(5 * time.Second):
+				case <-r.Context().Done():
+				}
+			},
+			wantClass: FailureTimeout,
+			wantHits:  2,
+		},
+		{
+			name: "oversized body",
+			path: "/big",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.Write([]byte(strings.Repeat("word ", 100_000)))
+			},
+			wantStatus: http.StatusOK,
+			wantClass:  FailureNone,
+			wantHits:   1,
+			check: func(t *testing.T, r CheckResult) {
+				if len(r.Body) != 4096 {
+					t.Errorf("read %d bytes of the body, want 4096", len(r.Body))
+				}
+			},
+		},
+	}
+
+	hits := map[string]*atomic.Int32{}
+	mux := http.NewServeMux()
+	for _, tt := range tests {
+		n := new(atomic.Int32)
+		hits[tt.path] = n
+		handler := tt.handler
+		mux.HandleFunc(tt.path, func(w http.ResponseWriter, r *http.Request) {
+			n.Add(1)
+			handler(w, r)
+		})
+	}
+	// Everything else, including soft-404 probes, is a real 404.
+	mux.HandleFunc("/", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sink := &recordingSink{}
+			c := NewChecker(sink, Options{})
+			c.Clock = newFakeClock()
+			c.Timeout = 300 * time.Millisecond
+			c.MaxBody = 4096
+			before := hits[tt.path].Load()
+
+			result, err := c.Check(context.Background(), Bookmark{URL: srv.URL + tt.path})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.ErrorClass != tt.wantClass {
+				t.Errorf("ErrorClass = %q, want %q", result.ErrorClass, tt.wantClass)
+			}
+			if tt.wantStatus != 0 && result.Status != tt.wantStatus {
+				t.Errorf("Status = %d, want %d", result.Status, tt.wantStatus)
+			}
+			if n := hits[tt.path].Load() - before; n != tt.wantHits {
+				t.Errorf("%s was fetched %d times, want %d", tt.path, n, tt.wantHits)
+			}
+			if len(sink.results) != 1 {
+				t.Errorf("sink got %d results, want 1", len(sink.results))
+			}
+			if tt.check != nil {
+				tt.check(t, result)
+			}
+		})
+	}
+}
+
+func TestSoft404(t *testing.T) {
+	page := func(title string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html><head><title>" + title + "</title></head><body><p>Text about " + title + "</p></body></html>"))
+		}
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{$}", page("Example home"))
+	mux.HandleFunc("/article", page("An article"))
+	mux.HandleFunc("/gone-girl", page("Gone Girl review"))
+	mux.HandleFunc("/http-404", page("HTTP 404 explained"))
+	mux.HandleFunc("/sorry", page("Page not found"))
+	mux.HandleFunc("/old-article", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/moved-article", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/article", http.StatusMovedPermanently)
+	})
+	mux.HandleFunc("/", http.NotFound)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	tests := []struct {
+		path       string
+		wantClass  FailureClass
+		wantTarget string
+	}{
+		{"/article", FailureNone, ""},
+		{"/moved-article", FailureNone, srv.URL + "/article"},
+		// Titles alone are not enough to call a page broken.
+		{"/gone-girl", FailureNone, ""},
+		{"/http-404", FailureNone, ""},
+		{"/sorry", FailureNone, ""},
+		// A deep link sent to the home page is gone, not moved.
+		{"/old-article", FailureSoft404, ""},
+	}
+	for _, tt := range tests {
+		c := NewChecker(discardSink{}, Options{})
+		result, err := c.Check(context.Background(), Bookmark{URL: srv.URL + tt.path})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ErrorClass != tt.wantClass {
+			t.Errorf("%s: ErrorClass = %q (%.2f), want %q", tt.path, result.ErrorClass, result.Soft404, tt.wantClass)
+		}
+		if target := result.PermanentTarget(); target != tt.wantTarget {
+			t.Errorf("%s: PermanentTarget() = %q, want %q", tt.path, target, tt.wantTarget)
+		}
+	}
+}
+
+func TestCheckConditional(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	c := NewChecker(discardSink{}, Options{HeadFirst: true})
+	etag := `"v1"`
+	result, err := c.Check(context.Background(), Bookmark{URL: srv.URL, ETag: &etag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != http.StatusNotModified || !result.Healthy() {
+		t.Errorf("Status = %d, healthy %v; want a healthy 304", result.Status, result.Healthy())
+	}
+	if result.ContentHash != nil {
+		t.Error("304 replaced the content hash")
+	}
+}
+
+func TestCheckHeadFirst(t *testing.T) {
+	v1, v2 := `"v1"`, `"v2"`
+	tests := []struct {
+		name    string
+		etag    *string // stored for the bookmark
+		head    int     // what the server answers HEAD with
+		serving string  // the page's current ETag
+		methods string
+		status  int
+		failure FailureClass
+	}{
+		{"unchanged", &v1, http.StatusOK, v1, "HEAD", http.StatusOK, FailureNone},
+		{"changed", &v1, http.StatusOK, v2, "HEAD GET", http.StatusOK, FailureNone},
+		{"head forbidden", &v1, http.StatusForbidden, v2, "HEAD GET", http.StatusOK, FailureNone},
+		{"head not found", &v1, http.StatusNotFound, v2, "HEAD GET", http.StatusOK, FailureNone},
+		{"head server error", &v1, http.StatusInternalServerError, v2, "HEAD GET", http.StatusOK, FailureNone},
+		{"no validators", nil, http.StatusOK, "", "GET", http.StatusOK, FailureNone},
+	}
+	for _, tt := range tests {
+		var mu sync.Mutex
+		var methods []string
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/page" {
+				http.NotFound(w, r)
+				return
+			}
+			mu.Lock()
+			methods = append(methods, r.Method)
+			mu.Unlock()
+			if tt.serving != "" {
+				w.Header().Set("ETag", tt.serving)
+			}
+			if r.Method == http.MethodHead {
+				w.WriteHeader(tt.head)
+				return
+			}
+			if tt.serving != "" && r.Header.Get("If-None-Match") == tt.serving {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Write([]byte("<title>Page</title><p>content</p>"))
+		}))
+
+		c := NewChecker(discardSink{}, Options{HeadFirst: true})
+		result, err := c.Check(context.Background(), Bookmark{URL: srv.URL + "/page", ETag: tt.etag})
+		srv.Close()
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got := strings.Join(methods, " "); got != tt.methods {
+			t.Errorf("%s: requests = %q, want %q", tt.name, got, tt.methods)
+		}
+		if result.Status != tt.status || result.ErrorClass != tt.failure {
+			t.Errorf("%s: result = %d %q, want %d %q", tt.name, result.Status, result.ErrorClass, tt.status, tt.failure)
+		}
+	}
+}
+
+func TestCheckUsesClock(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	c := NewChecker(discardSink{}, Options{})
+	c.Clock = clock
+
+	result, err := c.Check(context.Background(), Bookmark{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.CheckedAt.Equal(clock.Now()) || result.Latency != 0 {
+		t.Errorf("CheckedAt = %v, Latency = %s; want the fake clock's time", result.CheckedAt, result.Latency)
+	}
+}
+
+func TestHostLimiterClock(t *testing.T) {
+	clock := newFakeClock()
+	l := NewHostLimiter(1, time.Second)
+	l.Clock = clock
+	l.Backoff("example.com", clock.Now().Add(10*time.Second))
+
+	start := clock.Now()
+	realStart := time.Now()
+	for range 2 {
+		release, err := l.Acquire(context.Background(), "example.com")
+		if err != nil {
+			t.Fatal(err)
+		}
+		release()
+	}
+	if waited := clock.Now().Sub(start); waited != 11*time.Second {
+		t.Errorf("waited %s on the clock, want 11s", waited)
+	}
+	if real := time.Since(realStart); real > time.Second {
+		t.Errorf("waited %s of real time", real)
+	}
+}
+
+func TestRobotsCacheClock(t *testing.T) {
+	var fetches atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+	}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	cache := NewRobotsCache(time.Hour)
+	cache.Clock = clock
+	u, _ := http.NewRequest(http.MethodGet, srv.URL+"/private", nil)
+
+	for _, advance := range []time.Duration{0, 30 * time.Minute, 31 * time.Minute} {
+		clock.Advance(advance)
+		if cache.Allowed(context.Background(), srv.Client(), "Test/1.0", u.URL) {
+			t.Error("disallowed path was allowed")
+		}
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("robots.txt fetched %d times, want 2", n)
+	}
+}
+
+func TestSoft404ProbeCache(t *testing.T) {
+	var probes atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/cairn-probe-") {
+			probes.Add(1)
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<title>An article</title><p>Some words</p>"))
+	}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	c := NewChecker(discardSink{}, Options{})
+	c.Clock = clock
+
+	for _, advance := range []time.Duration{0, 23 * time.Hour, 2 * time.Hour} {
+		clock.Advance(advance)
+		result, err := c.Check(context.Background(), Bookmark{URL: srv.URL + "/article"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.ErrorClass != FailureNone {
+			t.Errorf("ErrorClass = %q", result.ErrorClass)
+		}
+	}
+	if n := probes.Load(); n != 2 {
+		t.Errorf("host probed %d times, want 2", n)
+	}
+}
@@ -0,0 +1,147 @@
+package bookmarks
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// FailureClass says why a check failed. The empty class means the check
+// succeeded.
+type FailureClass string
+
+const (
+	FailureNone             FailureClass = ""
+	FailureDNSNotFound      FailureClass = "dns_not_found"
+	FailureDNS              FailureClass = "dns_error"
+	FailureRefused          FailureClass = "connection_refused"
+	FailureReset            FailureClass = "connection_reset"
+	FailureTimeout          FailureClass = "timeout"
+	FailureTLSCertificate   FailureClass = "tls_certificate"
+	FailureTLSHandshake     FailureClass = "tls_handshake"
+	FailureTooManyRedirects FailureClass = "too_many_redirects"
+	FailureClientError      FailureClass = "client_error"
+	FailureServerError      FailureClass = "server_error"
+	FailureUnreachable      FailureClass = "unreachable"
+	FailureThrottled        FailureClass = "throttled"
+	FailureRobotsDisallowed FailureClass = "robots_disallowed"
+	FailureSoft404          FailureClass = "soft_404"
+)
+
+var failureLabels = map[FailureClass]string{
+	FailureDNSNotFound:      "domain not found",
+	FailureDNS:              "DNS lookup failed",
+	FailureRefused:          "connection refused",
+	FailureReset:            "connection reset",
+	FailureTimeout:          "timed out",
+	FailureTLSCertificate:   "invalid certificate",
+	FailureTLSHandshake:     "TLS handshake failed",
+	FailureTooManyRedirects: "too many redirects",
+	FailureClientError:      "client error",
+	FailureServerError:      "server error",
+	FailureUnreachable:      "unreachable",
+	FailureThrottled:        "throttled",
+	FailureRobotsDisallowed: "blocked by robots.txt",
+	FailureSoft404:          "soft 404",
+}
+
+func (c FailureClass) Label() string {
+	if l, ok := failureLabels[c]; ok {
+		return l
+	}
+	return string(c)
+}
+
+// Inconclusive reports whether a failure of this class says nothing about
+// whether the page is up.
+func (c FailureClass) Inconclusive() bool {
+	return c == FailureThrottled || c == FailureRobotsDisallowed
+}
+
+// Attempts is how many times a check failing with this class is tried in
+// total. Failures that won't go away by asking again, like a domain that
+// doesn't exist or an invalid certificate, are not retried.
+func (c FailureClass) Attempts() int {
+	switch c {
+	case FailureTimeout, FailureRefused, FailureServerError:
+		return 2
+	case FailureReset, FailureDNS:
+		return 3
+	default:
+		return 1
+	}
+}
+
+// retryBackoff is the pause before the given retry, starting at 1.
+func retryBackoff(retry int) time.Duration {
+	return time.Duration(retry) * 2 * time.Second
+}
+
+var errTooManyRedirects = errors.New("too many redirects")
+
+func classifyStatus(status int) FailureClass {
+	switch {
+	case status == http.StatusTooManyRequests:
+		return FailureThrottled
+	case status >= 500:
+		return FailureServerError
+	case status >= 400:
+		return FailureClientError
+	default:
+		return FailureNone
+	}
+}
+
+func classifyError(err error) FailureClass {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		if dnsErr.IsNotFound {
+			return FailureDNSNotFound
+		}
+		return FailureDNS
+	}
+
+	var certErr *tls.CertificateVerificationError
+	var unknownAuthority x509.UnknownAuthorityError
+	var invalidCert x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &certErr) || errors.As(err, &unknownAuthority) ||
+		errors.As(err, &invalidCert) || errors.As(err, &hostnameErr) {
+		return FailureTLSCertificate
+	}
+
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	if errors.As(err, &recordErr) || errors.As(err, &alertErr) {
+		return FailureTLSHandshake
+	}
+
+	switch {
+	case errors.Is(err, errTooManyRedirects):
+		return FailureTooManyRedirects
+	case errors.Is(err, errThrottled):
+		return FailureThrottled
+	case errors.Is(err, errRobotsDisallowed):
+		return FailureRobotsDisallowed
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return FailureTimeout
+	}
+
+	switch {
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return FailureReset
+	}
+
+	return FailureUnreachable
+}
@@ -1,22 +1,40 @@
 package bookmarks
 
 import (
+	"cmp"
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/archive"
+	"github.com/nemouu/cairn/internal/attachments"
+	"github.com/nemouu/cairn/internal/diff"
+	"github.com/nemouu/cairn/internal/entries"
 )
 
-func RegisterRoutes(mux *http.ServeMux, pool *pgxpool.Pool) {
+func RegisterRoutes(mux *http.ServeMux, pool *pgxpool.Pool, checker *Checker, scheduler *Scheduler, archiver *archive.Archiver) {
 	mux.HandleFunc("GET /bookmarks/new", handleForm(pool, false))
-	mux.HandleFunc("POST /bookmarks", handleCreate(pool))
-	mux.HandleFunc("GET /bookmarks/{id}", handleView(pool))
+	mux.HandleFunc("GET /bookmarks/import", handleImportForm())
+	mux.HandleFunc("POST /bookmarks/import", handleImport(pool))
+	mux.HandleFunc("GET /bookmarks/import/jobs/{job}", handleImportProgress())
+	mux.HandleFunc("GET /bookmarks/export", handleExport(pool))
+	mux.HandleFunc("GET /bookmarks/duplicates", handleDuplicates(pool))
+	mux.HandleFunc("GET /bookmarks/health", handleHealth(pool))
+	mux.HandleFunc("GET /bookmarks/health/feed.atom", handleBrokenFeed(pool))
+	mux.HandleFunc("POST /bookmarks/duplicates/merge", handleMerge(pool))
+	mux.HandleFunc("POST /bookmarks", handleCreate(pool, scheduler))
+	mux.HandleFunc("GET /bookmarks/{id}", handleView(pool, checker))
 	mux.HandleFunc("GET /bookmarks/{id}/edit", handleForm(pool, true))
 	mux.HandleFunc("POST /bookmarks/{id}", handleUpdate(pool))
 	mux.HandleFunc("POST /bookmarks/{id}/delete", handleDelete(pool))
-	mux.HandleFunc("POST /bookmarks/{id}/check", handleCheck(pool))
+	mux.HandleFunc("POST /bookmarks/{id}/check", handleCheck(pool, checker))
+	mux.HandleFunc("POST /bookmarks/{id}/canonicalize", handleCanonicalize(pool))
+	mux.HandleFunc("GET /bookmarks/{id}/archives/{archiveID}", handleArchive(pool, archiver))
 }
 
 func handleForm(pool *pgxpool.Pool, isEdit bool) http.HandlerFunc {
@@ -35,21 +53,58 @@ func handleForm(pool *pgxpool.Pool, isEdit bool) http.HandlerFunc {
 			}
 			data["Title"] = "Edit – " + entry.Title
 			data["Entry"] = entry
-			data["Bookmark"] = bookmark
+			data["FormTitle"] = entry.Title
+			data["FormURL"] = bookmark.URL
 		}
 
-		tmpl, err := template.ParseFiles("templates/layout.html", "internal/bookmarks/templates/form.html")
-		if err != nil {
-			http.Error(w, "template error", http.StatusInternalServerError)
-			return
-		}
-		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
-			log.Println("template render error:", err)
-		}
+		renderForm(w, data)
+	}
+}
+
+func renderForm(w http.ResponseWriter, data map[string]any) {
+	tmpl, err := template.ParseFiles("templates/layout.html", "internal/bookmarks/templates/form.html")
+	if err != nil {
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Println("template render error:", err)
+	}
+}
+
+// warnDuplicates shows the form again, listing the bookmarks that already
+// point at url, unless the user has seen the warning and saved anyway. It
+// reports whether it wrote a response. id is "" for a new bookmark.
+func warnDuplicates(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, id string, version int, title, url string) bool {
+	if r.FormValue("confirm") != "" {
+		return false
+	}
+	dups, err := FindDuplicates(r.Context(), pool, url, id)
+	if err != nil {
+		http.Error(w, "database error", http.StatusInternalServerError)
+		return true
 	}
+	if len(dups) == 0 {
+		return false
+	}
+
+	data := map[string]any{
+		"Title":      "New Bookmark",
+		"IsEdit":     id != "",
+		"FormTitle":  title,
+		"FormURL":    url,
+		"Duplicates": dups,
+	}
+	if id != "" {
+		data["Title"] = "Edit Bookmark"
+		data["Entry"] = entries.Entry{ID: id, Version: version}
+	}
+	w.WriteHeader(http.StatusConflict)
+	renderForm(w, data)
+	return true
 }
 
-func handleCreate(pool *pgxpool.Pool) http.HandlerFunc {
+func handleCreate(pool *pgxpool.Pool, scheduler *Scheduler) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		if err := r.ParseForm(); err != nil {
 			http.Error(w, "bad request", http.StatusBadRequest)
@@ -59,27 +114,33 @@ func handleCreate(pool *pgxpool.Pool) http.HandlerFunc {
 		title := strings.TrimSpace(r.FormValue("title"))
 		url := r.FormValue("url")
 
-		if title == "" {
-			http.Error(w, "title is required", http.StatusBadRequest)
+		if url == "" {
+			http.Error(w, "url is required", http.StatusBadRequest)
 			return
 		}
 
-		if url == "" {
-			http.Error(w, "url is required", http.StatusBadRequest)
+		if warnDuplicates(w, r, pool, "", 0, title, url) {
 			return
 		}
 
-		id, err := Create(r.Context(), pool, title, url)
+		// Without a title the URL stands in until the first check finds
+		// the page's own.
+		id, err := Create(r.Context(), pool, cmp.Or(title, url), url)
 		if err != nil {
 			http.Error(w, "database error", http.StatusInternalServerError)
 			return
 		}
 
+		// The new bookmark has never been checked, so it is the first the
+		// scheduler takes; waking it gets metadata, a status and an
+		// archived copy in shortly without holding up this request.
+		scheduler.Wake()
+
 		http.Redirect(w, r, "/bookmarks/"+id, http.StatusSeeOther)
 	}
 }
 
-func handleView(pool *pgxpool.Pool) http.HandlerFunc {
+func handleView(pool *pgxpool.Pool, checker *Checker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 
@@ -89,16 +150,73 @@ func handleView(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		tmpl, err := template.ParseFiles("templates/layout.html", "internal/bookmarks/templates/view.html")
+		checks, err := ListChecks(r.Context(), pool, id, 20)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		timeline, err := UptimeTimeline(r.Context(), pool, id, 30)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		uptime, err := UptimeSummary(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		snapshots, err := ListSnapshots(r.Context(), pool, id, 2)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		archives, err := ListArchives(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		tags, err := entries.GetTags(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		backlinks, err := entries.Backlinks(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		files, err := attachments.List(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "templates/backlinks.html", "templates/attachments.html", "internal/bookmarks/templates/view.html")
 		if err != nil {
 			http.Error(w, "template error", http.StatusInternalServerError)
 			return
 		}
 
 		data := map[string]any{
-			"Title":    entry.Title,
-			"Entry":    entry,
-			"Bookmark": bookmark,
+			"Title":       entry.Title,
+			"Entry":       entry,
+			"Bookmark":    bookmark,
+			"Checks":      checks,
+			"Timeline":    timeline,
+			"Uptime":      uptime,
+			"Archives":    archives,
+			"Tags":        tags,
+			"Backlinks":   backlinks,
+			"Attachments": files,
+			"CertWarning": checker.CertWarning,
+		}
+		if len(checks) > 0 {
+			data["Redirects"] = checks[0].Redirects
+		}
+		if len(snapshots) == 2 {
+			data["Diff"] = diff.Compact(diff.Lines(snapshots[1].Body, snapshots[0].Body), 3)
 		}
 		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
 			log.Println("template render error:", err)
@@ -117,9 +235,9 @@ func handleUpdate(pool *pgxpool.Pool) http.HandlerFunc {
 
 		title := strings.TrimSpace(r.FormValue("title"))
 		url := r.FormValue("url")
-
-		if title == "" {
-			http.Error(w, "title is required", http.StatusBadRequest)
+		version, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
 			return
 		}
 
@@ -128,7 +246,32 @@ func handleUpdate(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		if err := Update(r.Context(), pool, id, title, url); err != nil {
+		if warnDuplicates(w, r, pool, id, version, title, url) {
+			return
+		}
+
+		if title == "" {
+			_, bookmark, err := GetByID(r.Context(), pool, id)
+			if err != nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			title = url
+			if bookmark.PageTitle != nil && bookmark.URL == url {
+				title = *bookmark.PageTitle
+			}
+		}
+
+		err = Update(r.Context(), pool, id, title, url, version)
+		if errors.Is(err, entries.ErrConflict) {
+			renderConflict(w, r, pool, id, r.FormValue("title"), url)
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, "database error", http.StatusInternalServerError)
 			return
 		}
@@ -137,6 +280,36 @@ func handleUpdate(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// renderConflict answers a save refused because the bookmark changed in the
+// meantime, with what was submitted next to what is saved now.
+func renderConflict(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, id, title, url string) {
+	entry, bookmark, err := GetByID(r.Context(), pool, id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/layout.html", "templates/conflict.html")
+	if err != nil {
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Title":  "Conflict – " + entry.Title,
+		"Entry":  entry,
+		"Action": "/bookmarks/" + id,
+		"Fields": []entries.ConflictField{
+			{Name: "title", Label: "Title", Mine: title, Current: entry.Title},
+			{Name: "url", Label: "URL", Mine: url, Current: bookmark.URL},
+		},
+	}
+	w.WriteHeader(http.StatusConflict)
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Println("template render error:", err)
+	}
+}
+
 func handleDelete(pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
@@ -150,13 +323,224 @@ func handleDelete(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-func handleCheck(pool *pgxpool.Pool) http.HandlerFunc {
+func handleCheck(pool *pgxpool.Pool, checker *Checker) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
-		if err := Check(r.Context(), pool, id); err != nil {
+
+		_, bookmark, err := GetByID(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		if _, err := checker.Check(r.Context(), bookmark); err != nil {
 			http.Error(w, "check failed", http.StatusInternalServerError)
 			return
 		}
 		http.Redirect(w, r, "/bookmarks/"+id, http.StatusSeeOther)
 	}
 }
+
+func handleCanonicalize(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		if err := ApplySuggestedURL(r.Context(), pool, id); err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/bookmarks/"+id, http.StatusSeeOther)
+	}
+}
+
+func handleArchive(pool *pgxpool.Pool, archiver *archive.Archiver) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if archiver == nil {
+			http.Error(w, "archiving is disabled", http.StatusNotFound)
+			return
+		}
+
+		a, err := GetArchive(r.Context(), pool, r.PathValue("id"), r.PathValue("archiveID"))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		data, err := archiver.Load(r.Context(), a.BlobKey)
+		if err != nil {
+			log.Println("archive load error:", err)
+			http.Error(w, "archive unavailable", http.StatusNotFound)
+			return
+		}
+
+		archive.ServeArchive(w, a.ContentType, data)
+	}
+}
+
+// maxImportSize bounds an uploaded bookmarks file; browsers' exports of
+// tens of thousands of links stay well under it.
+const maxImportSize = 32 << 20
+
+func handleImportForm() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/bookmarks/templates/import.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		data := map[string]any{"Title": "Import Bookmarks"}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+func handleImport(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+		file, _, err := r.FormFile("file")
+		if err != nil {
+			http.Error(w, "bookmarks file is required", http.StatusBadRequest)
+			return
+		}
+		defer file.Close()
+
+		list, err := ParseNetscape(file)
+		if err != nil {
+			http.Error(w, "could not read bookmarks file", http.StatusBadRequest)
+			return
+		}
+
+		job := startImport(r.Context(), pool, list)
+		http.Redirect(w, r, "/bookmarks/import/jobs/"+job.ID, http.StatusSeeOther)
+	}
+}
+
+func handleImportProgress() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		job := findImport(r.PathValue("job"))
+		if job == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/bookmarks/templates/import.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		progress := job.Progress()
+		data := map[string]any{
+			"Title":    "Importing Bookmarks",
+			"Job":      job,
+			"Progress": progress,
+		}
+		if progress.Finished == nil {
+			data["Refresh"] = 2
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+func handleExport(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := ListForExport(r.Context(), pool)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="bookmarks.html"`)
+		if err := WriteNetscape(w, list); err != nil {
+			log.Println("export error:", err)
+		}
+	}
+}
+
+func handleDuplicates(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups, err := ListDuplicates(r.Context(), pool)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/bookmarks/templates/duplicates.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		data := map[string]any{
+			"Title":  "Duplicate Bookmarks",
+			"Groups": groups,
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+func handleMerge(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		keep := r.FormValue("keep")
+		if keep == "" {
+			http.Error(w, "choose a bookmark to keep", http.StatusBadRequest)
+			return
+		}
+
+		if err := Merge(r.Context(), pool, keep, r.Form["id"]); err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/bookmarks/duplicates", http.StatusSeeOther)
+	}
+}
+
+func handleHealth(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := ListHealth(r.Context(), pool)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/bookmarks/templates/health.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		sortBy := r.URL.Query().Get("sort")
+		data := map[string]any{
+			"Title":  "Link Health",
+			"Groups": GroupHealth(list, sortBy == "failing"),
+			"Sort":   sortBy,
+			"Total":  len(list),
+			"Feed":   "/bookmarks/health/feed.atom",
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+func handleBrokenFeed(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := ListBroken(r.Context(), pool, 50)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+		if err := WriteBrokenFeed(w, requestBaseURL(r), list); err != nil {
+			log.Println("feed error:", err)
+		}
+	}
+}
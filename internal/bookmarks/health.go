@@ -0,0 +1,209 @@
+package bookmarks
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HealthState is the group a bookmark falls into on the health report.
+type HealthState string
+
+const (
+	HealthUnreachable  HealthState = "unreachable"
+	HealthServerError  HealthState = "server_error"
+	HealthClientError  HealthState = "client_error"
+	HealthRedirected   HealthState = "redirected"
+	HealthDrifted      HealthState = "drifted"
+	HealthInconclusive HealthState = "inconclusive"
+	HealthNeverChecked HealthState = "never_checked"
+	HealthHealthy      HealthState = "healthy"
+)
+
+// healthStates is the order groups appear in on the report, worst first.
+var healthStates = []HealthState{
+	HealthUnreachable,
+	HealthServerError,
+	HealthClientError,
+	HealthRedirected,
+	HealthDrifted,
+	HealthInconclusive,
+	HealthNeverChecked,
+	HealthHealthy,
+}
+
+var healthLabels = map[HealthState]string{
+	HealthUnreachable:  "Unreachable",
+	HealthServerError:  "Server error",
+	HealthClientError:  "Client error",
+	HealthRedirected:   "Redirected",
+	HealthDrifted:      "Drifted",
+	HealthInconclusive: "Inconclusive",
+	HealthNeverChecked: "Never checked",
+	HealthHealthy:      "Healthy",
+}
+
+func (s HealthState) Label() string {
+	return healthLabels[s]
+}
+
+// driftWindow is how recently a page must have changed to count as drifted
+// rather than healthy.
+const driftWindow = 7 * 24 * time.Hour
+
+// HealthEntry is a bookmark as listed on the health report.
+type HealthEntry struct {
+	Title string
+	Bookmark
+}
+
+// State is the group the bookmark's last check puts it in. A check that
+// was throttled or disallowed by robots.txt says nothing about the page,
+// so it gets a group of its own rather than counting as broken.
+func (e HealthEntry) State() HealthState {
+	b := e.Bookmark
+	switch {
+	case b.LastStatus == nil:
+		return HealthNeverChecked
+	case b.LastErrorClass.Inconclusive():
+		return HealthInconclusive
+	case *b.LastStatus == 0:
+		return HealthUnreachable
+	case *b.LastStatus >= 500:
+		return HealthServerError
+	case *b.LastStatus >= 400, b.LastErrorClass == FailureSoft404:
+		return HealthClientError
+	case b.SuggestedURL != nil:
+		return HealthRedirected
+	case b.ContentChangedAt != nil && time.Since(*b.ContentChangedAt) < driftWindow:
+		return HealthDrifted
+	default:
+		return HealthHealthy
+	}
+}
+
+// FailingFor is how long the bookmark has been failing, rounded for display.
+func (e HealthEntry) FailingFor() string {
+	if e.FailingSince == nil {
+		return ""
+	}
+	d := time.Since(*e.FailingSince)
+	switch {
+	case d < time.Hour:
+		return fmt.Sprintf("%d min", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%d h", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days", int(d.Hours()/24))
+	}
+}
+
+// HealthGroup is the bookmarks of one state.
+type HealthGroup struct {
+	State   HealthState
+	Entries []HealthEntry
+}
+
+// GroupHealth sorts list into groups in report order, leaving out empty
+// ones. With byFailing set, bookmarks that have been failing longest come
+// first within each group; otherwise list order is kept.
+func GroupHealth(list []HealthEntry, byFailing bool) []HealthGroup {
+	byState := map[HealthState][]HealthEntry{}
+	for _, e := range list {
+		byState[e.State()] = append(byState[e.State()], e)
+	}
+
+	var groups []HealthGroup
+	for _, state := range healthStates {
+		entries := byState[state]
+		if len(entries) == 0 {
+			continue
+		}
+		if byFailing {
+			sort.SliceStable(entries, func(i, j int) bool {
+				a, b := entries[i].FailingSince, entries[j].FailingSince
+				if a == nil || b == nil {
+					return a != nil
+				}
+				return a.Before(*b)
+			})
+		}
+		groups = append(groups, HealthGroup{State: state, Entries: entries})
+	}
+	return groups
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Author  string      `xml:"author>name"`
+	Links   []atomLink  `xml:"link"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Href string `xml:"href,attr"`
+	Rel  string `xml:"rel,attr,omitempty"`
+	Type string `xml:"type,attr,omitempty"`
+}
+
+type atomEntry struct {
+	ID      string   `xml:"id"`
+	Title   string   `xml:"title"`
+	Updated string   `xml:"updated"`
+	Link    atomLink `xml:"link"`
+	Summary string   `xml:"summary"`
+}
+
+// WriteBrokenFeed writes an Atom feed with one entry per bookmark in list,
+// which must all be failing. Each stretch of failure gets its own entry ID,
+// so a bookmark that recovers and breaks again shows up again. baseURL is
+// the scheme and host the feed is served from.
+func WriteBrokenFeed(w io.Writer, baseURL string, list []HealthEntry) error {
+	feed := atomFeed{
+		ID:      baseURL + "/bookmarks/health",
+		Title:   "Broken bookmarks",
+		Author:  "Cairn",
+		Updated: time.Now().UTC().Format(time.RFC3339),
+		Links: []atomLink{
+			{Href: baseURL + "/bookmarks/health/feed.atom", Rel: "self", Type: "application/atom+xml"},
+			{Href: baseURL + "/bookmarks/health", Rel: "alternate", Type: "text/html"},
+		},
+	}
+	for i, e := range list {
+		since := e.FailingSince.UTC()
+		if i == 0 {
+			feed.Updated = since.Format(time.RFC3339)
+		}
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      fmt.Sprintf("%s/bookmarks/%s#failing-%d", baseURL, e.EntryID, since.Unix()),
+			Title:   fmt.Sprintf("%s: %s", e.Title, strings.ToLower(e.State().Label())),
+			Updated: since.Format(time.RFC3339),
+			Link:    atomLink{Href: baseURL + "/bookmarks/" + e.EntryID},
+			Summary: fmt.Sprintf("%s: %s since %s", e.URL, e.StatusLabel(), since.Format("2 Jan 2006, 15:04 MST")),
+		})
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	return enc.Encode(feed)
+}
+
+// requestBaseURL is the scheme and host r was addressed to, for links that
+// must be absolute.
+func requestBaseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host
+}
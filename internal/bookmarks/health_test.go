@@ -0,0 +1,34 @@
+package bookmarks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHealthState(t *testing.T) {
+	status := func(n int) *int { return &n }
+	recently := time.Now().Add(-time.Hour)
+	target := "https://example.com/new"
+
+	tests := []struct {
+		name string
+		b    Bookmark
+		want HealthState
+	}{
+		{"never checked", Bookmark{}, HealthNeverChecked},
+		{"healthy", Bookmark{LastStatus: status(200)}, HealthHealthy},
+		{"unreachable", Bookmark{LastStatus: status(0), LastErrorClass: FailureDNS}, HealthUnreachable},
+		{"server error", Bookmark{LastStatus: status(503), LastErrorClass: FailureServerError}, HealthServerError},
+		{"not found", Bookmark{LastStatus: status(404), LastErrorClass: FailureClientError}, HealthClientError},
+		{"soft 404", Bookmark{LastStatus: status(200), LastErrorClass: FailureSoft404}, HealthClientError},
+		{"throttled", Bookmark{LastStatus: status(429), LastErrorClass: FailureThrottled}, HealthInconclusive},
+		{"robots", Bookmark{LastStatus: status(0), LastErrorClass: FailureRobotsDisallowed}, HealthInconclusive},
+		{"redirected", Bookmark{LastStatus: status(200), SuggestedURL: &target}, HealthRedirected},
+		{"drifted", Bookmark{LastStatus: status(200), ContentChangedAt: &recently}, HealthDrifted},
+	}
+	for _, tt := range tests {
+		if got := (HealthEntry{Bookmark: tt.b}).State(); got != tt.want {
+			t.Errorf("%s: State() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package bookmarks
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ImportJob tracks a bookmarks file being imported in the background.
+type ImportJob struct {
+	ID      string
+	Started time.Time
+
+	mu       sync.Mutex
+	progress ImportProgress
+}
+
+// ImportProgress is a point-in-time view of an ImportJob.
+type ImportProgress struct {
+	Total    int
+	Imported int
+	Skipped  int
+	Failed   int
+	Errors   []string
+	Finished *time.Time
+}
+
+func (p ImportProgress) Processed() int {
+	return p.Imported + p.Skipped + p.Failed
+}
+
+func (p ImportProgress) Percent() int {
+	if p.Total == 0 {
+		return 100
+	}
+	return p.Processed() * 100 / p.Total
+}
+
+func (j *ImportJob) Progress() ImportProgress {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	p := j.progress
+	p.Errors = append([]string(nil), p.Errors...)
+	return p
+}
+
+func (j *ImportJob) update(f func(p *ImportProgress)) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	f(&j.progress)
+}
+
+// maxImportErrors caps how many failures a job keeps to show.
+const maxImportErrors = 20
+
+// run imports list one bookmark at a time, so a bad row only fails itself.
+func (j *ImportJob) run(ctx context.Context, pool *pgxpool.Pool, list []NetscapeBookmark) {
+	var titles []string
+	for _, nb := range list {
+		if u, err := url.Parse(nb.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			// Bookmarklets and browser-internal pages aren't links to check.
+			j.update(func(p *ImportProgress) { p.Skipped++ })
+			continue
+		}
+
+		created, err := Import(ctx, pool, nb)
+		j.update(func(p *ImportProgress) {
+			switch {
+			case err != nil:
+				p.Failed++
+				if len(p.Errors) < maxImportErrors {
+					p.Errors = append(p.Errors, fmt.Sprintf("%s: %v", nb.URL, err))
+				}
+			case created:
+				p.Imported++
+			default:
+				p.Skipped++
+			}
+		})
+		if err != nil {
+			log.Printf("import %s: %v", nb.URL, err)
+		}
+		if created {
+			titles = append(titles, cmp.Or(nb.Title, nb.URL))
+		}
+	}
+
+	// Notes are linked once for the whole import rather than per bookmark,
+	// which would go through every note each time.
+	if err := LinkImported(ctx, pool, titles); err != nil {
+		log.Printf("import: linking notes: %v", err)
+	}
+
+	now := time.Now()
+	j.update(func(p *ImportProgress) { p.Finished = &now })
+}
+
+// importJobs holds the jobs of this process. Progress is only for watching
+// an import finish, so it isn't worth a table; finished jobs are dropped
+// after importJobTTL.
+var importJobs = struct {
+	sync.Mutex
+	jobs map[string]*ImportJob
+}{jobs: map[string]*ImportJob{}}
+
+const importJobTTL = time.Hour
+
+// startImport imports list in the background and returns the job tracking
+// it. The import outlives the request that started it.
+func startImport(ctx context.Context, pool *pgxpool.Pool, list []NetscapeBookmark) *ImportJob {
+	job := &ImportJob{
+		ID:       rand.Text(),
+		Started:  time.Now(),
+		progress: ImportProgress{Total: len(list)},
+	}
+
+	importJobs.Lock()
+	for id, j := range importJobs.jobs {
+		if f := j.Progress().Finished; f != nil && time.Since(*f) > importJobTTL {
+			delete(importJobs.jobs, id)
+		}
+	}
+	importJobs.jobs[job.ID] = job
+	importJobs.Unlock()
+
+	go job.run(context.WithoutCancel(ctx), pool, list)
+	return job
+}
+
+func findImport(id string) *ImportJob {
+	importJobs.Lock()
+	defer importJobs.Unlock()
+	return importJobs.jobs[id]
+}
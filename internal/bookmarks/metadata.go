@@ -0,0 +1,138 @@
+package bookmarks
+
+import (
+	"bytes"
+	"mime"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// PageMetadata is what a page says about itself in its <head>.
+type PageMetadata struct {
+	Title        string
+	Description  string
+	SiteName     string
+	ImageURL     string
+	CanonicalURL string
+	FaviconURL   string
+	Lang         string
+}
+
+// ExtractMetadata reads the title, description, OpenGraph and Twitter card
+// fields, canonical link, favicon and language from an HTML page. URLs are
+// resolved against pageURL. OpenGraph values win over Twitter card values,
+// which win over the plain HTML ones.
+func ExtractMetadata(pageURL, contentType string, body []byte) (PageMetadata, bool) {
+	var m PageMetadata
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType != "text/html" && mediaType != "application/xhtml+xml" &&
+		!(mediaType == "" && looksLikeHTML(body)) {
+		return m, false
+	}
+
+	base, err := url.Parse(pageURL)
+	if err != nil {
+		return m, false
+	}
+	r, err := charset.NewReader(bytes.NewReader(body), contentType)
+	if err != nil {
+		return m, false
+	}
+	doc, err := html.Parse(r)
+	if err != nil {
+		return m, false
+	}
+
+	// Each field is looked up under OpenGraph, Twitter card and plain HTML
+	// names, in that order of preference.
+	title := map[string]string{}
+	description := map[string]string{}
+	image := map[string]string{}
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			switch n.DataAtom {
+			case atom.Html:
+				m.Lang = strings.TrimSpace(attrValue(n, "lang"))
+			case atom.Title:
+				if n.FirstChild != nil && title["html"] == "" {
+					title["html"] = n.FirstChild.Data
+				}
+			case atom.Meta:
+				key := strings.ToLower(attrValue(n, "property"))
+				if key == "" {
+					key = strings.ToLower(attrValue(n, "name"))
+				}
+				content := attrValue(n, "content")
+				prefix, field, ok := strings.Cut(key, ":")
+				if !ok {
+					prefix, field = "html", key
+				}
+				switch field {
+				case "title":
+					title[prefix] = content
+				case "description":
+					description[prefix] = content
+				case "image":
+					image[prefix] = content
+				}
+				switch key {
+				case "og:site_name":
+					m.SiteName = strings.TrimSpace(content)
+				}
+			case atom.Link:
+				for _, rel := range strings.Fields(strings.ToLower(attrValue(n, "rel"))) {
+					switch rel {
+					case "canonical":
+						m.CanonicalURL = resolveURL(base, attrValue(n, "href"))
+					case "icon", "apple-touch-icon":
+						if m.FaviconURL == "" {
+							m.FaviconURL = resolveURL(base, attrValue(n, "href"))
+						}
+					}
+				}
+			case atom.Body:
+				// Everything we want lives in <head> and on <html>.
+				return
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+
+	m.Title = preferred(title)
+	m.Description = preferred(description)
+	m.ImageURL = resolveURL(base, preferred(image))
+	if m.FaviconURL == "" {
+		m.FaviconURL = resolveURL(base, "/favicon.ico")
+	}
+	return m, true
+}
+
+func preferred(values map[string]string) string {
+	for _, source := range []string{"og", "twitter", "html"} {
+		if v := strings.Join(strings.Fields(values[source]), " "); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func resolveURL(base *url.URL, ref string) string {
+	ref = strings.TrimSpace(ref)
+	if ref == "" {
+		return ""
+	}
+	u, err := base.Parse(ref)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return ""
+	}
+	return u.String()
+}
@@ -0,0 +1,190 @@
+package bookmarks
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	xhtml "golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+	"golang.org/x/net/html/charset"
+)
+
+// NetscapeBookmark is one link of a Netscape bookmarks file, the
+// bookmarks.html format that browsers import and export.
+type NetscapeBookmark struct {
+	Title        string
+	URL          string
+	AddDate      time.Time
+	LastModified time.Time
+	// Tags are the names of the folders the link was filed under, followed
+	// by the link's own TAGS.
+	Tags []string
+}
+
+// ParseNetscape reads every link from a Netscape bookmarks file. Browsers
+// write these files as loosely nested HTML, so it follows the <DL> nesting
+// for folders rather than relying on a well-formed tree.
+func ParseNetscape(r io.Reader) ([]NetscapeBookmark, error) {
+	r, err := charset.NewReader(r, "text/html")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		list    []NetscapeBookmark
+		folders []string // one per open <DL>; "" for lists that aren't folders
+		pending string   // folder named by the last <H3>, waiting for its <DL>
+		text    strings.Builder
+		inH3    bool
+		current *NetscapeBookmark
+	)
+	z := xhtml.NewTokenizer(r)
+	for {
+		switch z.Next() {
+		case xhtml.ErrorToken:
+			if err := z.Err(); err != io.EOF {
+				return nil, err
+			}
+			return list, nil
+
+		case xhtml.StartTagToken:
+			tok := z.Token()
+			switch tok.DataAtom {
+			case atom.H3:
+				inH3 = true
+				text.Reset()
+				// The toolbar and "other bookmarks" folders are the browser's
+				// own containers, not something the user filed links under.
+				if tokenAttr(tok, "personal_toolbar_folder") != "" ||
+					tokenAttr(tok, "unfiled_bookmarks_folder") != "" {
+					inH3 = false
+					pending = ""
+				}
+			case atom.Dl:
+				folders = append(folders, pending)
+				pending = ""
+			case atom.A:
+				href := strings.TrimSpace(tokenAttr(tok, "href"))
+				if href == "" {
+					continue
+				}
+				current = &NetscapeBookmark{
+					URL:          href,
+					AddDate:      netscapeTime(tokenAttr(tok, "add_date")),
+					LastModified: netscapeTime(tokenAttr(tok, "last_modified")),
+				}
+				for _, f := range folders {
+					if f != "" {
+						current.Tags = appendTag(current.Tags, f)
+					}
+				}
+				for _, t := range strings.Split(tokenAttr(tok, "tags"), ",") {
+					current.Tags = appendTag(current.Tags, t)
+				}
+				text.Reset()
+			}
+
+		case xhtml.EndTagToken:
+			name, _ := z.TagName()
+			switch atom.Lookup(name) {
+			case atom.H3:
+				if inH3 {
+					pending = strings.TrimSpace(text.String())
+					inH3 = false
+				}
+			case atom.Dl:
+				if len(folders) > 0 {
+					folders = folders[:len(folders)-1]
+				}
+			case atom.A:
+				if current != nil {
+					current.Title = strings.Join(strings.Fields(text.String()), " ")
+					list = append(list, *current)
+					current = nil
+				}
+			}
+
+		case xhtml.TextToken:
+			if inH3 || current != nil {
+				text.Write(z.Text())
+			}
+		}
+	}
+}
+
+// WriteNetscape writes list as a Netscape bookmarks file. Tags go into the
+// TAGS attribute, which Firefox and most bookmark services read back.
+func WriteNetscape(w io.Writer, list []NetscapeBookmark) error {
+	_, err := io.WriteString(w, `<!DOCTYPE NETSCAPE-Bookmark-file-1>
+<!-- This is an automatically generated file.
+     It will be read and overwritten.
+     DO NOT EDIT! -->
+<META HTTP-EQUIV="Content-Type" CONTENT="text/html; charset=UTF-8">
+<TITLE>Bookmarks</TITLE>
+<H1>Bookmarks</H1>
+<DL><p>
+`)
+	if err != nil {
+		return err
+	}
+	for _, nb := range list {
+		attrs := fmt.Sprintf(` HREF="%s"`, html.EscapeString(nb.URL))
+		if !nb.AddDate.IsZero() {
+			attrs += fmt.Sprintf(` ADD_DATE="%d"`, nb.AddDate.Unix())
+		}
+		if !nb.LastModified.IsZero() {
+			attrs += fmt.Sprintf(` LAST_MODIFIED="%d"`, nb.LastModified.Unix())
+		}
+		if len(nb.Tags) > 0 {
+			attrs += fmt.Sprintf(` TAGS="%s"`, html.EscapeString(strings.Join(nb.Tags, ",")))
+		}
+		_, err := fmt.Fprintf(w, "    <DT><A%s>%s</A>\n", attrs, html.EscapeString(nb.Title))
+		if err != nil {
+			return err
+		}
+	}
+	_, err = io.WriteString(w, "</DL><p>\n")
+	return err
+}
+
+func tokenAttr(tok xhtml.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func appendTag(tags []string, name string) []string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return tags
+	}
+	for _, t := range tags {
+		if t == name {
+			return tags
+		}
+	}
+	return append(tags, name)
+}
+
+// netscapeTime parses an ADD_DATE or LAST_MODIFIED value. These are meant to
+// be Unix seconds, but some exporters write milliseconds or microseconds.
+func netscapeTime(v string) time.Time {
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n <= 0 {
+		return time.Time{}
+	}
+	switch {
+	case n > 1e14:
+		return time.UnixMicro(n)
+	case n > 1e11:
+		return time.UnixMilli(n)
+	}
+	return time.Unix(n, 0)
+}
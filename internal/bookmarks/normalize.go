@@ -0,0 +1,145 @@
+package bookmarks
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"mime"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Elements whose contents are never part of the readable page.
+var skippedElements = map[atom.Atom]bool{
+	atom.Head:     true,
+	atom.Script:   true,
+	atom.Style:    true,
+	atom.Noscript: true,
+	atom.Template: true,
+	atom.Svg:      true,
+	atom.Iframe:   true,
+	atom.Object:   true,
+	atom.Canvas:   true,
+	atom.Select:   true,
+	atom.Textarea: true,
+}
+
+// Elements that start a new line of text.
+var blockElements = map[atom.Atom]bool{
+	atom.Address: true, atom.Article: true, atom.Aside: true, atom.Blockquote: true,
+	atom.Br: true, atom.Dd: true, atom.Div: true, atom.Dl: true, atom.Dt: true,
+	atom.Figcaption: true, atom.Figure: true, atom.Footer: true, atom.Form: true,
+	atom.H1: true, atom.H2: true, atom.H3: true, atom.H4: true, atom.H5: true, atom.H6: true,
+	atom.Header: true, atom.Hr: true, atom.Li: true, atom.Main: true, atom.Nav: true,
+	atom.Ol: true, atom.P: true, atom.Pre: true, atom.Section: true, atom.Table: true,
+	atom.Td: true, atom.Th: true, atom.Tr: true, atom.Ul: true,
+}
+
+// Tokens that change between two loads of an otherwise identical page.
+var volatilePatterns = []*regexp.Regexp{
+	// ISO 8601 timestamps and bare clock times
+	regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}(:\d{2}(\.\d+)?)?(Z|[+-]\d{2}:?\d{2})?`),
+	regexp.MustCompile(`\b\d{1,2}:\d{2}(:\d{2})?(\s?[AaPp][Mm])?\b`),
+	// UUIDs
+	regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`),
+	// Long hex or base64 strings: nonces, tokens, cache busters
+	regexp.MustCompile(`\b[0-9a-fA-F]{16,}\b`),
+	regexp.MustCompile(`[A-Za-z0-9+/_-]{32,}={0,2}`),
+	// Unix timestamps in seconds or milliseconds
+	regexp.MustCompile(`\b1\d{9}(\d{3})?\b`),
+}
+
+var spaceRun = regexp.MustCompile(`\s+`)
+
+// NormalizeContent extracts the readable text of a response body so that
+// markup, scripts and per-request tokens don't register as content changes.
+// The second return value is false for content types that have no readable
+// text, such as images.
+func NormalizeContent(contentType string, body []byte) (string, bool) {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	switch {
+	case mediaType == "text/html" || mediaType == "application/xhtml+xml" ||
+		(mediaType == "" && looksLikeHTML(body)):
+		return normalizeText(extractHTMLText(body)), true
+	case strings.HasPrefix(mediaType, "text/"):
+		return normalizeText(string(body)), true
+	default:
+		return "", false
+	}
+}
+
+func HashContent(s string) string {
+	hash := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(hash[:])
+}
+
+func looksLikeHTML(body []byte) bool {
+	head := bytes.ToLower(body[:min(len(body), 512)])
+	return bytes.Contains(head, []byte("<html")) || bytes.Contains(head, []byte("<!doctype html"))
+}
+
+func extractHTMLText(body []byte) string {
+	doc, err := html.Parse(bytes.NewReader(body))
+	if err != nil {
+		return string(body)
+	}
+
+	var b strings.Builder
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			if skippedElements[n.DataAtom] || hasAttr(n, "hidden") {
+				return
+			}
+			if blockElements[n.DataAtom] {
+				b.WriteByte('\n')
+				defer b.WriteByte('\n')
+			}
+		}
+		if n.Type == html.TextNode {
+			b.WriteString(n.Data)
+			b.WriteByte(' ')
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+	return b.String()
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func attrValue(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func normalizeText(s string) string {
+	for _, re := range volatilePatterns {
+		s = re.ReplaceAllString(s, "")
+	}
+
+	var lines []string
+	for _, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(spaceRun.ReplaceAllString(line, " "))
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
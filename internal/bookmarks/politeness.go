@@ -0,0 +1,184 @@
+package bookmarks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const DefaultUserAgent = "Cairn/1.0 (+https://github.com/nemouu/cairn)"
+
+var (
+	errThrottled        = errors.New("host is throttling requests")
+	errRobotsDisallowed = errors.New("disallowed by robots.txt")
+)
+
+// HostLimiter spaces out requests to the same host. At most MaxPerHost
+// requests to a host are in flight at once, consecutive requests start at
+// least Delay apart, and a host that answered with Retry-After is left alone
+// until that time has passed.
+type HostLimiter struct {
+	MaxPerHost int
+	Delay      time.Duration
+	// MaxWait is the longest a request waits out a host's Retry-After.
+	// Requests that would have to wait longer, or past their context's
+	// deadline, fail as throttled instead of timing out.
+	MaxWait time.Duration
+	// Clock is what delays are measured and waited out with; the real
+	// clock if nil.
+	Clock Clock
+
+	mu    sync.Mutex
+	hosts map[string]*hostState
+}
+
+type hostState struct {
+	slots        chan struct{}
+	next         time.Time
+	blockedUntil time.Time
+}
+
+func NewHostLimiter(maxPerHost int, delay time.Duration) *HostLimiter {
+	if maxPerHost < 1 {
+		maxPerHost = 1
+	}
+	return &HostLimiter{
+		MaxPerHost: maxPerHost,
+		Delay:      delay,
+		MaxWait:    30 * time.Second,
+		hosts:      make(map[string]*hostState),
+	}
+}
+
+func (l *HostLimiter) clock() Clock {
+	if l.Clock == nil {
+		return realClock{}
+	}
+	return l.Clock
+}
+
+func (l *HostLimiter) state(host string) *hostState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	h, ok := l.hosts[host]
+	if !ok {
+		h = &hostState{slots: make(chan struct{}, l.MaxPerHost)}
+		l.hosts[host] = h
+	}
+	return h
+}
+
+// Acquire waits until a request to host may start. The returned function
+// must be called once the request is done.
+func (l *HostLimiter) Acquire(ctx context.Context, host string) (func(), error) {
+	h := l.state(host)
+	select {
+	case h.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-h.slots }
+
+	l.mu.Lock()
+	now := l.clock().Now()
+	start := now
+	if h.next.After(start) {
+		start = h.next
+	}
+	if h.blockedUntil.After(start) {
+		start = h.blockedUntil
+	}
+	// A request that can't start in time would only time out and make the
+	// host look broken.
+	deadline, hasDeadline := ctx.Deadline()
+	if h.blockedUntil.Sub(now) > l.MaxWait || (hasDeadline && !start.Before(deadline)) {
+		l.mu.Unlock()
+		release()
+		return nil, errThrottled
+	}
+	h.next = start.Add(l.Delay)
+	l.mu.Unlock()
+
+	if wait := start.Sub(now); wait > 0 {
+		select {
+		case <-l.clock().After(wait):
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
+
+// Backoff keeps requests away from host until the given time.
+func (l *HostLimiter) Backoff(host string, until time.Time) {
+	h := l.state(host)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if until.After(h.blockedUntil) {
+		h.blockedUntil = until
+	}
+}
+
+// politeTransport applies the User-Agent, robots.txt and per-host limits
+// from Options to every request, including each hop of a redirect chain.
+type politeTransport struct {
+	base http.RoundTripper
+	opts Options
+}
+
+func (t *politeTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", t.opts.userAgent())
+
+	if t.opts.Robots != nil && req.URL.Path != "/robots.txt" {
+		robotsClient := &http.Client{Transport: t, Timeout: 10 * time.Second}
+		if !t.opts.Robots.Allowed(req.Context(), robotsClient, t.opts.userAgent(), req.URL) {
+			return nil, errRobotsDisallowed
+		}
+	}
+
+	if t.opts.Hosts != nil {
+		release, err := t.opts.Hosts.Acquire(req.Context(), req.URL.Host)
+		if err != nil {
+			return nil, err
+		}
+		defer release()
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.opts.Hosts != nil &&
+		(resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		now := t.opts.Hosts.clock().Now()
+		if until, ok := retryAfter(resp.Header.Get("Retry-After"), now); ok {
+			t.opts.Hosts.Backoff(req.URL.Host, until)
+		} else if resp.StatusCode == http.StatusTooManyRequests {
+			t.opts.Hosts.Backoff(req.URL.Host, now.Add(time.Minute))
+		}
+	}
+	return resp, nil
+}
+
+// retryAfter parses a Retry-After header, which holds either a number of
+// seconds or an HTTP date.
+func retryAfter(v string, now time.Time) (time.Time, bool) {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return time.Time{}, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return now.Add(time.Duration(secs) * time.Second), true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		return t, true
+	}
+	return time.Time{}, false
+}
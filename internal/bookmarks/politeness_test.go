@@ -0,0 +1,174 @@
+package bookmarks
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// discardSink drops every result; tests look at what Check returns.
+type discardSink struct{}
+
+func (discardSink) Record(ctx context.Context, b Bookmark, result CheckResult) error { return nil }
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Date(2026, 10, 17, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		header string
+		want   time.Time
+		ok     bool
+	}{
+		{"", time.Time{}, false},
+		{"120", now.Add(2 * time.Minute), true},
+		{" 0 ", now, true},
+		{"-5", time.Time{}, false},
+		{"Sat, 17 Oct 2026 12:05:00 GMT", now.Add(5 * time.Minute), true},
+		{"soon", time.Time{}, false},
+	}
+	for _, tt := range tests {
+		got, ok := retryAfter(tt.header, now)
+		if ok != tt.ok || !got.Equal(tt.want) {
+			t.Errorf("retryAfter(%q) = %v, %v; want %v, %v", tt.header, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+func TestHostLimiterWaitsOutBackoff(t *testing.T) {
+	l := NewHostLimiter(1, 0)
+	l.Backoff("example.com", time.Now().Add(100*time.Millisecond))
+
+	start := time.Now()
+	release, err := l.Acquire(context.Background(), "example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+	if waited := time.Since(start); waited < 90*time.Millisecond {
+		t.Errorf("Acquire returned after %s, before the backoff ended", waited)
+	}
+}
+
+func TestHostLimiterThrottlesPastDeadline(t *testing.T) {
+	l := NewHostLimiter(1, 0)
+	l.Backoff("example.com", time.Now().Add(20*time.Second))
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	start := time.Now()
+	_, err := l.Acquire(ctx, "example.com")
+	if !errors.Is(err, errThrottled) {
+		t.Fatalf("Acquire = %v, want errThrottled", err)
+	}
+	if waited := time.Since(start); waited > 100*time.Millisecond {
+		t.Errorf("Acquire waited %s before giving up", waited)
+	}
+}
+
+func TestHostLimiterThrottlesPastMaxWait(t *testing.T) {
+	l := NewHostLimiter(1, 0)
+	l.MaxWait = time.Second
+	l.Backoff("example.com", time.Now().Add(time.Minute))
+
+	if _, err := l.Acquire(context.Background(), "example.com"); !errors.Is(err, errThrottled) {
+		t.Fatalf("Acquire = %v, want errThrottled", err)
+	}
+}
+
+func TestCheckThrottledHost(t *testing.T) {
+	var hits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		w.Header().Set("Retry-After", "20")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+
+	c := NewChecker(discardSink{}, Options{Hosts: NewHostLimiter(1, 0)})
+	c.Timeout = 2 * time.Second
+	b := Bookmark{URL: srv.URL + "/page"}
+
+	first, err := c.Check(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.ErrorClass != FailureThrottled || first.Status != http.StatusTooManyRequests {
+		t.Errorf("first check = %q (%d), want throttled (429)", first.ErrorClass, first.Status)
+	}
+
+	// Retry-After is longer than the check may take, so the second check
+	// gives up at once rather than timing out.
+	start := time.Now()
+	second, err := c.Check(context.Background(), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ErrorClass != FailureThrottled {
+		t.Errorf("second check = %q, want throttled", second.ErrorClass)
+	}
+	if !second.Inconclusive() {
+		t.Error("throttled check is not inconclusive")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("second check took %s", elapsed)
+	}
+	if n := hits.Load(); n != 1 {
+		t.Errorf("server was hit %d times, want 1", n)
+	}
+}
+
+func TestCheckRobotsDisallowed(t *testing.T) {
+	var pageHits atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/robots.txt":
+			w.Write([]byte("User-agent: *\nDisallow: /private\n"))
+		case "/private/page", "/public":
+			pageHits.Add(1)
+			w.Write([]byte("<title>ok</title>"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	c := NewChecker(discardSink{}, Options{Robots: NewRobotsCache(time.Hour)})
+
+	result, err := c.Check(context.Background(), Bookmark{URL: srv.URL + "/private/page"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ErrorClass != FailureRobotsDisallowed {
+		t.Errorf("disallowed page = %q, want robots_disallowed", result.ErrorClass)
+	}
+	if n := pageHits.Load(); n != 0 {
+		t.Errorf("disallowed page was fetched %d times", n)
+	}
+
+	result, err = c.Check(context.Background(), Bookmark{URL: srv.URL + "/public"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ErrorClass != FailureNone {
+		t.Errorf("allowed page = %q, want no failure", result.ErrorClass)
+	}
+}
+
+func TestCheckSendsUserAgent(t *testing.T) {
+	var got atomic.Value
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got.Store(r.UserAgent())
+	}))
+	defer srv.Close()
+
+	c := NewChecker(discardSink{}, Options{UserAgent: "Test/1.0"})
+	if _, err := c.Check(context.Background(), Bookmark{URL: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+	if ua, _ := got.Load().(string); ua != "Test/1.0" {
+		t.Errorf("User-Agent = %q, want Test/1.0", ua)
+	}
+}
@@ -1,10 +1,17 @@
 package bookmarks
 
 import (
+	"cmp"
 	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/archive"
 	"github.com/nemouu/cairn/internal/entries"
 )
 
@@ -14,6 +21,167 @@ type Bookmark struct {
 	LastStatus    *int
 	LastCheckedAt *time.Time
 	ContentHash   *string
+	FailingSince  *time.Time
+	// ContentChangedAt is when a check last found different content than
+	// the check before it.
+	ContentChangedAt *time.Time
+	LastErrorClass   FailureClass
+	// SuggestedURL is where the bookmark permanently redirects to, if the
+	// last check found such a redirect.
+	SuggestedURL *string
+	ETag         *string
+	LastModified *string
+	// Soft404 is the soft-404 confidence from the last successful fetch.
+	Soft404 *float64
+	// Page metadata from the last successful fetch of an HTML page.
+	PageTitle    *string
+	Description  *string
+	SiteName     *string
+	ImageURL     *string
+	CanonicalURL *string
+	FaviconURL   *string
+	Lang         *string
+	// Certificate of the host, as last seen by a check over HTTPS.
+	CertIssuer   *string
+	CertSubject  *string
+	CertSANs     []string
+	CertNotAfter *time.Time
+}
+
+func (b Bookmark) StatusClass() string {
+	switch {
+	case b.LastStatus == nil:
+		return "unknown"
+	case b.LastErrorClass == FailureSoft404:
+		return "bad"
+	case *b.LastStatus >= 200 && *b.LastStatus < 300, *b.LastStatus == http.StatusNotModified:
+		return "ok"
+	case *b.LastStatus >= 300 && *b.LastStatus < 400, b.LastErrorClass.Inconclusive():
+		return "warn"
+	default:
+		return "bad"
+	}
+}
+
+func (b Bookmark) StatusLabel() string {
+	switch {
+	case b.LastStatus == nil:
+		return "not checked"
+	case *b.LastStatus == 0:
+		return b.LastErrorClass.Label()
+	default:
+		return strconv.Itoa(*b.LastStatus)
+	}
+}
+
+// OtherCanonical returns the canonical URL the page declares, or "" when
+// it is the bookmarked URL itself.
+func (b Bookmark) OtherCanonical() string {
+	if b.CanonicalURL == nil || *b.CanonicalURL == b.URL {
+		return ""
+	}
+	return *b.CanonicalURL
+}
+
+// CertState is "expired" or "expiring" when the bookmark's certificate has
+// run out or will within warning, and "" otherwise.
+func (b Bookmark) CertState(warning time.Duration) string {
+	if b.CertNotAfter == nil {
+		return ""
+	}
+	left := time.Until(*b.CertNotAfter)
+	switch {
+	case left <= 0:
+		return "expired"
+	case left <= warning:
+		return "expiring"
+	}
+	return ""
+}
+
+func (b Bookmark) CertDaysLeft() int {
+	if b.CertNotAfter == nil {
+		return 0
+	}
+	return int(time.Until(*b.CertNotAfter).Hours() / 24)
+}
+
+func (b Bookmark) Soft404Percent() string {
+	if b.Soft404 == nil {
+		return ""
+	}
+	return fmt.Sprintf("%.0f%%", 100**b.Soft404)
+}
+
+// CheckRecord is one row of a bookmark's check history.
+type CheckRecord struct {
+	CheckedAt   time.Time
+	Status      int
+	LatencyMS   int
+	FinalURL    *string
+	ContentHash *string
+	ErrorClass  FailureClass
+	Redirects   []Redirect
+	Soft404     *float64
+}
+
+func (c CheckRecord) Healthy() bool {
+	return c.ErrorClass == FailureNone && c.Status >= 200 && c.Status < 400
+}
+
+// UptimeDay summarises the checks made on one calendar day.
+type UptimeDay struct {
+	Day     time.Time
+	Checks  int
+	Healthy int
+}
+
+func (d UptimeDay) State() string {
+	switch {
+	case d.Checks == 0:
+		return "none"
+	case d.Healthy == d.Checks:
+		return "up"
+	case d.Healthy == 0:
+		return "down"
+	default:
+		return "partial"
+	}
+}
+
+// Snapshot is the normalized text of a page as it was at one point in time.
+type Snapshot struct {
+	TakenAt     time.Time
+	ContentHash string
+	Body        string
+}
+
+// ArchiveRecord describes one archived copy of a bookmarked page.
+type ArchiveRecord struct {
+	ID          string
+	CreatedAt   time.Time
+	ContentType string
+	Size        int
+	BlobKey     string
+	// Partial is set if some of the page's resources are missing.
+	Partial bool
+}
+
+func (a ArchiveRecord) IsText() bool {
+	return strings.HasPrefix(a.ContentType, "text/plain")
+}
+
+type Uptime struct {
+	Label   string
+	Checks  int
+	Healthy int
+}
+
+func (u Uptime) Percent() string {
+	if u.Checks == 0 {
+		return "–"
+	}
+	return fmt.Sprintf("%.1f%%", 100*float64(u.Healthy)/float64(u.Checks))
 }
 
 func Create(ctx context.Context, pool *pgxpool.Pool, title, url string) (string, error) {
@@ -23,24 +191,112 @@ func Create(ctx context.Context, pool *pgxpool.Pool, title, url string) (string,
 	}
 	defer tx.Rollback(ctx)
 
-	var id string
+	id, err := insert(ctx, tx, title, url, nil)
+	if err != nil {
+		return "", err
+	}
+	if err := entries.LinkTitle(ctx, tx, id, title); err != nil {
+		return "", err
+	}
+
+	return id, tx.Commit(ctx)
+}
+
+// Import creates a bookmark read from a bookmarks file, unless one for the
+// same page already exists. It reports whether the bookmark was created.
+// Notes are not linked to it; LinkImported does that for a whole import.
+func Import(ctx context.Context, pool *pgxpool.Pool, nb NetscapeBookmark) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
 	err = tx.QueryRow(ctx,
-		`INSERT INTO entries (entry_type, title) VALUES ('bookmark', $1) RETURNING id`,
-		title,
+		`SELECT EXISTS (SELECT 1 FROM bookmarks WHERE normalized_url = $1)`,
+		NormalizeURL(nb.URL),
+	).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+
+	var createdAt *time.Time
+	if !nb.AddDate.IsZero() {
+		createdAt = &nb.AddDate
+	}
+	id, err := insert(ctx, tx, cmp.Or(nb.Title, nb.URL), nb.URL, createdAt)
+	if err != nil {
+		return false, err
+	}
+	if len(nb.Tags) > 0 {
+		if err := entries.SetTags(ctx, tx, id, nb.Tags); err != nil {
+			return false, err
+		}
+	}
+
+	return true, tx.Commit(ctx)
+}
+
+// LinkImported links notes to bookmarks just imported under titles.
+func LinkImported(ctx context.Context, pool *pgxpool.Pool, titles []string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := entries.LinkTitles(ctx, tx, titles); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// insert adds the entry and bookmark rows. A nil createdAt means now.
+func insert(ctx context.Context, tx pgx.Tx, title, url string, createdAt *time.Time) (string, error) {
+	var id string
+	err := tx.QueryRow(ctx,
+		`INSERT INTO entries (entry_type, title, created_at, updated_at)
+         VALUES ('bookmark', $1, COALESCE($2, now()), COALESCE($2, now()))
+         RETURNING id`,
+		title, createdAt,
 	).Scan(&id)
 	if err != nil {
 		return "", err
 	}
 
 	_, err = tx.Exec(ctx,
-		`INSERT INTO notes (entry_id, url) VALUES ($1, $2)`,
-		id, url,
+		`INSERT INTO bookmarks (entry_id, url, normalized_url) VALUES ($1, $2, $3)`,
+		id, url, NormalizeURL(url),
 	)
+	return id, err
+}
+
+// ListForExport returns every bookmark with its tags, oldest first.
+func ListForExport(ctx context.Context, pool *pgxpool.Pool) ([]NetscapeBookmark, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT e.title, b.url, e.created_at, e.updated_at,
+                COALESCE(array_agg(t.name ORDER BY t.name) FILTER (WHERE t.name IS NOT NULL), '{}')
+         FROM bookmarks b
+         JOIN entries e ON e.id = b.entry_id
+         LEFT JOIN entry_tags et ON et.entry_id = e.id
+         LEFT JOIN tags t ON t.id = et.tag_id
+         GROUP BY e.id, b.url
+         ORDER BY e.created_at`)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	defer rows.Close()
 
-	return id, tx.Commit(ctx)
+	var list []NetscapeBookmark
+	for rows.Next() {
+		var nb NetscapeBookmark
+		if err := rows.Scan(&nb.Title, &nb.URL, &nb.AddDate, &nb.LastModified, &nb.Tags); err != nil {
+			return nil, err
+		}
+		list = append(list, nb)
+	}
+	return list, rows.Err()
 }
 
 func GetByID(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry, Bookmark, error) {
@@ -48,37 +304,52 @@ func GetByID(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry,
 	var b Bookmark
 
 	err := pool.QueryRow(ctx,
-		`SELECT e.id, e.entry_type, e.title, e.created_at, e.updated_at,
-            b.url, b.last_status, b.last_checked_at, b.content_hash
+		`SELECT e.id, e.entry_type, e.title, e.created_at, e.updated_at, e.version,
+            b.url, b.last_status, b.last_checked_at, b.content_hash, b.failing_since,
+            b.content_changed_at, COALESCE(b.last_error_class, ''),
+            b.suggested_url, b.etag, b.last_modified,
+            b.soft404_confidence,
+            b.page_title, b.description, b.site_name, b.image_url,
+            b.canonical_url, b.favicon_url, b.lang,
+            b.cert_issuer, b.cert_subject, b.cert_sans, b.cert_not_after
      FROM entries e
      JOIN bookmarks b ON b.entry_id = e.id
      WHERE e.id = $1`,
 		id,
-	).Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt,
-		&b.URL, &b.LastStatus, &b.LastCheckedAt, &b.ContentHash)
+	).Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt, &e.Version,
+		&b.URL, &b.LastStatus, &b.LastCheckedAt, &b.ContentHash, &b.FailingSince,
+		&b.ContentChangedAt, &b.LastErrorClass,
+		&b.SuggestedURL, &b.ETag, &b.LastModified,
+		&b.Soft404,
+		&b.PageTitle, &b.Description, &b.SiteName, &b.ImageURL,
+		&b.CanonicalURL, &b.FaviconURL, &b.Lang,
+		&b.CertIssuer, &b.CertSubject, &b.CertSANs, &b.CertNotAfter)
 
 	b.EntryID = e.ID
 	return e, b, err
 }
 
-func Update(ctx context.Context, pool *pgxpool.Pool, id, title, url string) error {
+// Update saves a bookmark loaded at version. It returns entries.ErrConflict
+// if the bookmark has been saved since.
+func Update(ctx context.Context, pool *pgxpool.Pool, id, title, url string, version int) error {
 	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx,
-		`UPDATE entries SET title = $1, updated_at = now() WHERE id = $2`,
-		title, id,
-	)
-	if err != nil {
+	if err := entries.UpdateTitle(ctx, tx, id, title, version); err != nil {
 		return err
 	}
 
 	_, err = tx.Exec(ctx,
-		`UPDATE bookmarks SET url = $1 WHERE entry_id = $2`,
-		url, id,
+		`UPDATE bookmarks
+         SET etag = CASE WHEN url = $1 THEN etag END,
+             last_modified = CASE WHEN url = $1 THEN last_modified END,
+             url = $1,
+             normalized_url = $3
+         WHERE entry_id = $2`,
+		url, id, NormalizeURL(url),
 	)
 	if err != nil {
 		return err
@@ -95,12 +366,711 @@ func Delete(ctx context.Context, pool *pgxpool.Pool, id string) error {
 	return err
 }
 
-func UpdateCheckResult(ctx context.Context, pool *pgxpool.Pool, id string, status int, contentHash *string) error {
-	_, err := pool.Exec(ctx,
+// UpdateCheckResult stores the latest result on the bookmark and appends it
+// to the bookmark's check history. A content hash that differs from the
+// previous one marks the bookmark as changed and keeps a new snapshot.
+func UpdateCheckResult(ctx context.Context, pool *pgxpool.Pool, id string, result CheckResult) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var prevHash *string
+	err = tx.QueryRow(ctx,
+		`SELECT content_hash FROM bookmarks WHERE entry_id = $1 FOR UPDATE`,
+		id,
+	).Scan(&prevHash)
+	if err != nil {
+		return err
+	}
+
+	newContent := result.ContentHash != nil && (prevHash == nil || *prevHash != *result.ContentHash)
+	changed := newContent && prevHash != nil
+
+	if newContent && result.Snapshot != nil {
+		_, err = tx.Exec(ctx,
+			`INSERT INTO bookmark_snapshots (entry_id, taken_at, content_hash, body)
+             VALUES ($1, $2, $3, $4)`,
+			id, result.CheckedAt, *result.ContentHash, *result.Snapshot,
+		)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(ctx,
+			`DELETE FROM bookmark_snapshots
+             WHERE entry_id = $1 AND id NOT IN (
+                 SELECT id FROM bookmark_snapshots
+                 WHERE entry_id = $1
+                 ORDER BY taken_at DESC
+                 LIMIT $2
+             )`,
+			id, snapshotsKept,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO bookmark_checks
+             (entry_id, checked_at, status, latency_ms, final_url, content_hash,
+              error_class, redirects, soft404_confidence)
+         VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, NULLIF($7, ''), $8, $9)`,
+		id, result.CheckedAt, result.Status, result.Latency.Milliseconds(), result.FinalURL,
+		result.ContentHash, string(result.ErrorClass), result.Redirects, result.soft404(),
+	)
+	if err != nil {
+		return err
+	}
+
+	// A response without a certificate means the page is now served over
+	// plain HTTP; a failed connection says nothing either way.
+	if result.Cert != nil || result.Status != 0 {
+		var c CertInfo
+		if result.Cert != nil {
+			c = *result.Cert
+		}
+		var notAfter *time.Time
+		if !c.NotAfter.IsZero() {
+			notAfter = &c.NotAfter
+		}
+		_, err = tx.Exec(ctx,
+			`UPDATE bookmarks
+             SET cert_issuer = NULLIF($1, ''), cert_subject = NULLIF($2, ''),
+                 cert_sans = $3, cert_not_after = $4
+             WHERE entry_id = $5`,
+			c.Issuer, c.Subject, c.SANs, notAfter, id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m := result.Metadata; m != nil {
+		_, err = tx.Exec(ctx,
+			`UPDATE bookmarks
+             SET page_title = NULLIF($1, ''), description = NULLIF($2, ''),
+                 site_name = NULLIF($3, ''), image_url = NULLIF($4, ''),
+                 canonical_url = NULLIF($5, ''), favicon_url = NULLIF($6, ''),
+                 lang = NULLIF($7, '')
+             WHERE entry_id = $8`,
+			m.Title, m.Description, m.SiteName, m.ImageURL,
+			m.CanonicalURL, m.FaviconURL, m.Lang, id,
+		)
+		if err != nil {
+			return err
+		}
+
+		// A bookmark saved without a title has its URL for one until the
+		// page names itself.
+		if m.Title != "" {
+			tag, err := tx.Exec(ctx,
+				`UPDATE entries e
+                 SET title = $1, updated_at = now(), version = e.version + 1
+                 FROM bookmarks b
+                 WHERE e.id = $2 AND b.entry_id = e.id AND e.title = b.url`,
+				m.Title, id,
+			)
+			if err != nil {
+				return err
+			}
+			if tag.RowsAffected() > 0 {
+				if err := entries.LinkTitle(ctx, tx, id, m.Title); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	_, err = tx.Exec(ctx,
 		`UPDATE bookmarks
-         SET last_status = $1, last_checked_at = now(), content_hash = $2
+         SET last_status = $1, last_checked_at = $9,
+             content_hash = COALESCE($2, content_hash),
+             content_changed_at = CASE WHEN $3 THEN $9 ELSE content_changed_at END,
+             failing_since = CASE
+                 WHEN $4 THEN NULL
+                 WHEN $8 THEN failing_since
+                 ELSE COALESCE(failing_since, $9)
+             END,
+             last_error_class = NULLIF($5, ''),
+             suggested_url = NULLIF(NULLIF($6, ''), url),
+             etag = COALESCE(NULLIF($10, ''), etag),
+             last_modified = COALESCE(NULLIF($11, ''), last_modified),
+             soft404_confidence = CASE WHEN $13 THEN $12 ELSE soft404_confidence END,
+             check_claimed_until = NULL
+         WHERE entry_id = $7`,
+		result.Status, result.ContentHash, changed, result.Healthy(),
+		string(result.ErrorClass), result.PermanentTarget(), id, result.Inconclusive(),
+		result.CheckedAt, result.ETag, result.LastModified,
+		result.Soft404, result.Snapshot != nil,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ApplySuggestedURL moves the bookmark to the URL its last check was
+// permanently redirected to.
+func ApplySuggestedURL(ctx context.Context, pool *pgxpool.Pool, id string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var suggested *string
+	err = tx.QueryRow(ctx,
+		`SELECT suggested_url FROM bookmarks WHERE entry_id = $1 FOR UPDATE`,
+		id,
+	).Scan(&suggested)
+	if err != nil {
+		return err
+	}
+	if suggested == nil {
+		return nil
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE bookmarks SET url = $1, normalized_url = $2, suggested_url = NULL
          WHERE entry_id = $3`,
-		status, contentHash, id,
+		*suggested, NormalizeURL(*suggested), id,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE entries SET updated_at = now(), version = version + 1 WHERE id = $1`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// FindDuplicates returns the bookmarks, other than excludeID, that point at
+// the same page as url.
+func FindDuplicates(ctx context.Context, pool *pgxpool.Pool, url, excludeID string) ([]entries.Entry, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT e.id, e.entry_type, e.title, e.created_at, e.updated_at
+         FROM bookmarks b
+         JOIN entries e ON e.id = b.entry_id
+         WHERE b.normalized_url = $1 AND ($2 = '' OR b.entry_id::text <> $2)
+         ORDER BY e.created_at`,
+		NormalizeURL(url), excludeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []entries.Entry
+	for rows.Next() {
+		var e entries.Entry
+		if err := rows.Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// DuplicateGroup is a set of bookmarks that point at the same page.
+type DuplicateGroup struct {
+	NormalizedURL string
+	Bookmarks     []DuplicateBookmark
+}
+
+type DuplicateBookmark struct {
+	EntryID   string
+	Title     string
+	URL       string
+	CreatedAt time.Time
+}
+
+// ListDuplicates returns every group of two or more bookmarks sharing a
+// normalized URL, oldest bookmark first within each group.
+func ListDuplicates(ctx context.Context, pool *pgxpool.Pool) ([]DuplicateGroup, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT b.normalized_url, e.id, e.title, b.url, e.created_at
+         FROM bookmarks b
+         JOIN entries e ON e.id = b.entry_id
+         WHERE b.normalized_url IN (
+             SELECT normalized_url FROM bookmarks
+             GROUP BY normalized_url
+             HAVING count(*) > 1
+         )
+         ORDER BY b.normalized_url, e.created_at`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var groups []DuplicateGroup
+	for rows.Next() {
+		var norm string
+		var d DuplicateBookmark
+		if err := rows.Scan(&norm, &d.EntryID, &d.Title, &d.URL, &d.CreatedAt); err != nil {
+			return nil, err
+		}
+		if len(groups) == 0 || groups[len(groups)-1].NormalizedURL != norm {
+			groups = append(groups, DuplicateGroup{NormalizedURL: norm})
+		}
+		g := &groups[len(groups)-1]
+		g.Bookmarks = append(g.Bookmarks, d)
+	}
+	return groups, rows.Err()
+}
+
+// Merge folds the bookmarks in ids into keepID and deletes them. The kept
+// bookmark gains their tags and links and the earliest creation time. Only
+// bookmarks of the same page as keepID are merged.
+func Merge(ctx context.Context, pool *pgxpool.Pool, keepID string, ids []string) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	// Narrow ids to real duplicates of keepID, locking them as we go.
+	rows, err := tx.Query(ctx,
+		`SELECT b.entry_id::text
+         FROM bookmarks b
+         JOIN bookmarks k ON k.normalized_url = b.normalized_url
+         WHERE k.entry_id = $1 AND b.entry_id <> $1 AND b.entry_id::text = ANY($2)
+         FOR UPDATE OF b`,
+		keepID, ids,
+	)
+	if err != nil {
+		return err
+	}
+	var merged []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		merged = append(merged, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+
+	statements := []string{
+		`INSERT INTO entry_tags (entry_id, tag_id)
+         SELECT $1::uuid, tag_id FROM entry_tags WHERE entry_id::text = ANY($2)
+         ON CONFLICT DO NOTHING`,
+		`INSERT INTO entry_links (source_id, target_id)
+         SELECT $1::uuid, target_id FROM entry_links
+         WHERE source_id::text = ANY($2) AND target_id <> $1::uuid
+         ON CONFLICT DO NOTHING`,
+		`INSERT INTO entry_links (source_id, target_id)
+         SELECT source_id, $1::uuid FROM entry_links
+         WHERE target_id::text = ANY($2) AND source_id <> $1::uuid
+         ON CONFLICT DO NOTHING`,
+		`UPDATE entries
+         SET created_at = LEAST(created_at,
+                 (SELECT min(created_at) FROM entries WHERE id::text = ANY($2))),
+             updated_at = now(),
+             version = version + 1
+         WHERE id = $1`,
+		`DELETE FROM entries WHERE id::text = ANY($2) AND id <> $1`,
+	}
+	for _, sql := range statements {
+		if _, err := tx.Exec(ctx, sql, keepID, merged); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// BackfillNormalizedURLs fills in the normalized URL of bookmarks saved
+// before it was stored. It returns how many bookmarks it updated.
+func BackfillNormalizedURLs(ctx context.Context, pool *pgxpool.Pool) (int, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT entry_id, url FROM bookmarks WHERE normalized_url IS NULL`)
+	if err != nil {
+		return 0, err
+	}
+	type pending struct{ id, url string }
+	var todo []pending
+	for rows.Next() {
+		var p pending
+		if err := rows.Scan(&p.id, &p.url); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		todo = append(todo, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, p := range todo {
+		_, err := pool.Exec(ctx,
+			`UPDATE bookmarks SET normalized_url = $1 WHERE entry_id = $2 AND url = $3`,
+			NormalizeURL(p.url), p.id, p.url,
+		)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(todo), nil
+}
+
+func HasArchive(ctx context.Context, pool *pgxpool.Pool, id, contentHash string) (bool, error) {
+	var exists bool
+	err := pool.QueryRow(ctx,
+		`SELECT EXISTS(
+             SELECT 1 FROM bookmark_archives WHERE entry_id = $1 AND content_hash = $2
+         )`,
+		id, contentHash,
+	).Scan(&exists)
+	return exists, err
+}
+
+func AddArchive(ctx context.Context, pool *pgxpool.Pool, id, contentHash string, c archive.Capture) error {
+	_, err := pool.Exec(ctx,
+		`INSERT INTO bookmark_archives (entry_id, content_hash, content_type, size, blob_key, partial)
+         VALUES ($1, $2, $3, $4, $5, $6)`,
+		id, contentHash, c.ContentType, len(c.Data), c.Key, c.Partial,
 	)
 	return err
 }
+
+// SweepArchives removes stored copies that no archive refers to any more,
+// such as those of deleted bookmarks. It returns how many were removed.
+// Copies stored in the last hour are left alone, as the archives that
+// refer to them may not have been added yet.
+func SweepArchives(ctx context.Context, pool *pgxpool.Pool, store archive.Store) (int, error) {
+	before := time.Now().Add(-time.Hour)
+	keys, err := store.Keys(ctx, before)
+	if err != nil || len(keys) == 0 {
+		return 0, err
+	}
+
+	rows, err := pool.Query(ctx,
+		`SELECT DISTINCT blob_key FROM bookmark_archives WHERE blob_key = ANY($1)`,
+		keys,
+	)
+	if err != nil {
+		return 0, err
+	}
+	used := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		used[key] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, key := range keys {
+		if used[key] {
+			continue
+		}
+		ok, err := store.Delete(ctx, key, before)
+		if err != nil {
+			return removed, err
+		}
+		if ok {
+			removed++
+		}
+	}
+	return removed, nil
+}
+
+func ListArchives(ctx context.Context, pool *pgxpool.Pool, id string) ([]ArchiveRecord, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT id, created_at, content_type, size, blob_key, partial
+         FROM bookmark_archives
+         WHERE entry_id = $1
+         ORDER BY created_at DESC`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var archives []ArchiveRecord
+	for rows.Next() {
+		var a ArchiveRecord
+		if err := rows.Scan(&a.ID, &a.CreatedAt, &a.ContentType, &a.Size, &a.BlobKey, &a.Partial); err != nil {
+			return nil, err
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
+
+func GetArchive(ctx context.Context, pool *pgxpool.Pool, id, archiveID string) (ArchiveRecord, error) {
+	var a ArchiveRecord
+	err := pool.QueryRow(ctx,
+		`SELECT id, created_at, content_type, size, blob_key, partial
+         FROM bookmark_archives
+         WHERE entry_id = $1 AND id = $2`,
+		id, archiveID,
+	).Scan(&a.ID, &a.CreatedAt, &a.ContentType, &a.Size, &a.BlobKey, &a.Partial)
+	return a, err
+}
+
+// snapshotsKept is how many snapshots are kept per bookmark.
+const snapshotsKept = 10
+
+func ListSnapshots(ctx context.Context, pool *pgxpool.Pool, id string, limit int) ([]Snapshot, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT taken_at, content_hash, body
+         FROM bookmark_snapshots
+         WHERE entry_id = $1
+         ORDER BY taken_at DESC
+         LIMIT $2`,
+		id, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var s Snapshot
+		if err := rows.Scan(&s.TakenAt, &s.ContentHash, &s.Body); err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, s)
+	}
+	return snapshots, rows.Err()
+}
+
+func ListChecks(ctx context.Context, pool *pgxpool.Pool, id string, limit int) ([]CheckRecord, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT checked_at, status, latency_ms, final_url, content_hash,
+                COALESCE(error_class, ''), redirects, soft404_confidence
+         FROM bookmark_checks
+         WHERE entry_id = $1
+         ORDER BY checked_at DESC
+         LIMIT $2`,
+		id, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var checks []CheckRecord
+	for rows.Next() {
+		var c CheckRecord
+		err := rows.Scan(&c.CheckedAt, &c.Status, &c.LatencyMS, &c.FinalURL, &c.ContentHash, &c.ErrorClass, &c.Redirects, &c.Soft404)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, c)
+	}
+	return checks, rows.Err()
+}
+
+// UptimeTimeline returns one UptimeDay per calendar day for the last days
+// days, oldest first, including days without any checks. Inconclusive
+// checks are left out, here and in UptimeSummary.
+func UptimeTimeline(ctx context.Context, pool *pgxpool.Pool, id string, days int) ([]UptimeDay, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT d.day,
+                count(c.id),
+                count(c.id) FILTER (WHERE c.status BETWEEN 200 AND 399 AND c.error_class IS NULL)
+         FROM generate_series(current_date - ($2 - 1), current_date, interval '1 day') AS d(day)
+         LEFT JOIN bookmark_checks c
+                ON c.entry_id = $1
+               AND c.checked_at >= d.day
+               AND c.checked_at < d.day + interval '1 day'
+               AND COALESCE(c.error_class, '') NOT IN ('throttled', 'robots_disallowed')
+         GROUP BY d.day
+         ORDER BY d.day`,
+		id, days,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var timeline []UptimeDay
+	for rows.Next() {
+		var d UptimeDay
+		if err := rows.Scan(&d.Day, &d.Checks, &d.Healthy); err != nil {
+			return nil, err
+		}
+		timeline = append(timeline, d)
+	}
+	return timeline, rows.Err()
+}
+
+func UptimeSummary(ctx context.Context, pool *pgxpool.Pool, id string) ([]Uptime, error) {
+	windows := []Uptime{{Label: "24 hours"}, {Label: "7 days"}, {Label: "30 days"}}
+	err := pool.QueryRow(ctx,
+		`SELECT count(*) FILTER (WHERE checked_at > now() - interval '1 day'),
+                count(*) FILTER (WHERE checked_at > now() - interval '1 day' AND status BETWEEN 200 AND 399 AND error_class IS NULL),
+                count(*) FILTER (WHERE checked_at > now() - interval '7 days'),
+                count(*) FILTER (WHERE checked_at > now() - interval '7 days' AND status BETWEEN 200 AND 399 AND error_class IS NULL),
+                count(*),
+                count(*) FILTER (WHERE status BETWEEN 200 AND 399 AND error_class IS NULL)
+         FROM bookmark_checks
+         WHERE entry_id = $1 AND checked_at > now() - interval '30 days'
+           AND COALESCE(error_class, '') NOT IN ('throttled', 'robots_disallowed')`,
+		id,
+	).Scan(&windows[0].Checks, &windows[0].Healthy,
+		&windows[1].Checks, &windows[1].Healthy,
+		&windows[2].Checks, &windows[2].Healthy)
+	return windows, err
+}
+
+// ClaimDue reserves up to limit bookmarks that have not been checked within
+// interval and returns their IDs. Rows locked or leased by another instance
+// are skipped.
+func ClaimDue(ctx context.Context, pool *pgxpool.Pool, interval, lease time.Duration, limit int) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`UPDATE bookmarks
+         SET check_claimed_until = now() + make_interval(secs => $2)
+         WHERE entry_id IN (
+             SELECT entry_id FROM bookmarks
+             WHERE (last_checked_at IS NULL OR last_checked_at < now() - make_interval(secs => $1))
+               AND (check_claimed_until IS NULL OR check_claimed_until < now())
+             ORDER BY last_checked_at NULLS FIRST
+             LIMIT $3
+             FOR UPDATE SKIP LOCKED
+         )
+         RETURNING entry_id`,
+		interval.Seconds(), lease.Seconds(), limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func ReleaseClaims(ctx context.Context, pool *pgxpool.Pool, ids []string) error {
+	_, err := pool.Exec(ctx,
+		`UPDATE bookmarks SET check_claimed_until = NULL WHERE entry_id = ANY($1)`,
+		ids,
+	)
+	return err
+}
+
+// CertExpiry is a bookmark whose certificate is about to run out.
+type CertExpiry struct {
+	EntryID  string
+	Title    string
+	URL      string
+	NotAfter time.Time
+}
+
+func (c CertExpiry) Expired() bool {
+	return !c.NotAfter.After(time.Now())
+}
+
+func (c CertExpiry) DaysLeft() int {
+	return int(time.Until(c.NotAfter).Hours() / 24)
+}
+
+// ListExpiringCerts returns the bookmarks whose certificates have expired or
+// expire within the given duration, soonest first.
+func ListExpiringCerts(ctx context.Context, pool *pgxpool.Pool, within time.Duration) ([]CertExpiry, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT e.id, e.title, b.url, b.cert_not_after
+         FROM bookmarks b
+         JOIN entries e ON e.id = b.entry_id
+         WHERE b.cert_not_after < now() + make_interval(secs => $1)
+         ORDER BY b.cert_not_after`,
+		within.Seconds(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []CertExpiry
+	for rows.Next() {
+		var c CertExpiry
+		if err := rows.Scan(&c.EntryID, &c.Title, &c.URL, &c.NotAfter); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+const healthColumns = `e.id, e.title, b.url, b.last_status, b.last_checked_at,
+            b.failing_since, b.content_changed_at,
+            COALESCE(b.last_error_class, ''), b.suggested_url`
+
+// ListHealth returns every bookmark with what the health report needs,
+// ordered by title.
+func ListHealth(ctx context.Context, pool *pgxpool.Pool) ([]HealthEntry, error) {
+	return queryHealth(ctx, pool,
+		`SELECT `+healthColumns+`
+         FROM bookmarks b
+         JOIN entries e ON e.id = b.entry_id
+         ORDER BY lower(e.title)`)
+}
+
+// ListBroken returns the bookmarks that are currently failing, most
+// recently broken first.
+func ListBroken(ctx context.Context, pool *pgxpool.Pool, limit int) ([]HealthEntry, error) {
+	return queryHealth(ctx, pool,
+		`SELECT `+healthColumns+`
+         FROM bookmarks b
+         JOIN entries e ON e.id = b.entry_id
+         WHERE b.failing_since IS NOT NULL
+         ORDER BY b.failing_since DESC
+         LIMIT $1`,
+		limit,
+	)
+}
+
+func queryHealth(ctx context.Context, pool *pgxpool.Pool, sql string, args ...any) ([]HealthEntry, error) {
+	rows, err := pool.Query(ctx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []HealthEntry
+	for rows.Next() {
+		var e HealthEntry
+		err := rows.Scan(&e.EntryID, &e.Title, &e.URL, &e.LastStatus, &e.LastCheckedAt,
+			&e.FailingSince, &e.ContentChangedAt, &e.LastErrorClass, &e.SuggestedURL)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,172 @@
+package bookmarks
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RobotsCache fetches and caches robots.txt per site.
+type RobotsCache struct {
+	TTL time.Duration
+	// Clock decides when a cached robots.txt is too old; the real clock if
+	// nil.
+	Clock Clock
+
+	mu    sync.Mutex
+	sites map[string]robotsEntry
+}
+
+type robotsEntry struct {
+	body      string
+	fetchedAt time.Time
+}
+
+func NewRobotsCache(ttl time.Duration) *RobotsCache {
+	return &RobotsCache{TTL: ttl, sites: make(map[string]robotsEntry)}
+}
+
+func (c *RobotsCache) clock() Clock {
+	if c.Clock == nil {
+		return realClock{}
+	}
+	return c.Clock
+}
+
+// Allowed reports whether userAgent may fetch u. A site whose robots.txt
+// can't be fetched allows everything.
+func (c *RobotsCache) Allowed(ctx context.Context, client *http.Client, userAgent string, u *url.URL) bool {
+	site := u.Scheme + "://" + u.Host
+
+	c.mu.Lock()
+	entry, ok := c.sites[site]
+	c.mu.Unlock()
+
+	if !ok || c.clock().Now().Sub(entry.fetchedAt) > c.TTL {
+		entry = robotsEntry{body: fetchRobots(ctx, client, site), fetchedAt: c.clock().Now()}
+		c.mu.Lock()
+		c.sites[site] = entry
+		c.mu.Unlock()
+	}
+
+	path := u.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if u.RawQuery != "" {
+		path += "?" + u.RawQuery
+	}
+	return robotsAllowed(entry.body, userAgent, path)
+}
+
+func fetchRobots(ctx context.Context, client *http.Client, site string) string {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, site+"/robots.txt", nil)
+	if err != nil {
+		return ""
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 512<<10))
+	return string(body)
+}
+
+type robotsRule struct {
+	allow   bool
+	pattern string
+	re      *regexp.Regexp
+}
+
+// robotsAllowed applies the group of robots.txt rules that best matches
+// userAgent to path. The longest matching rule wins, and Allow wins a tie.
+func robotsAllowed(robots, userAgent, path string) bool {
+	rules := robotsRules(robots, robotsToken(userAgent))
+
+	allowed, best := true, -1
+	for _, r := range rules {
+		if r.pattern == "" || !r.re.MatchString(path) {
+			continue
+		}
+		if len(r.pattern) > best || (len(r.pattern) == best && r.allow) {
+			allowed, best = r.allow, len(r.pattern)
+		}
+	}
+	return allowed
+}
+
+// robotsToken is the product name a robots.txt group would use for
+// userAgent, e.g. "cairn" for "Cairn/1.0 (+https://…)".
+func robotsToken(userAgent string) string {
+	token, _, _ := strings.Cut(userAgent, "/")
+	token, _, _ = strings.Cut(token, " ")
+	return strings.ToLower(token)
+}
+
+func robotsRules(robots, token string) []robotsRule {
+	var specific, wildcard []robotsRule
+	var agents []string
+	inRules := false
+
+	scanner := bufio.NewScanner(strings.NewReader(robots))
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "user-agent":
+			if inRules {
+				agents, inRules = nil, false
+			}
+			agents = append(agents, strings.ToLower(value))
+		case "allow", "disallow":
+			inRules = true
+			rule := robotsRule{allow: key == "allow", pattern: value, re: robotsPattern(value)}
+			for _, a := range agents {
+				switch {
+				case a == "*":
+					wildcard = append(wildcard, rule)
+				case token != "" && strings.Contains(a, token):
+					specific = append(specific, rule)
+				}
+			}
+		}
+	}
+
+	if specific != nil {
+		return specific
+	}
+	return wildcard
+}
+
+// robotsPattern turns a robots.txt path pattern, which may use * and a
+// trailing $, into an anchored regular expression.
+func robotsPattern(pattern string) *regexp.Regexp {
+	anchored := strings.HasSuffix(pattern, "$")
+	pattern = strings.TrimSuffix(pattern, "$")
+
+	parts := strings.Split(pattern, "*")
+	for i, p := range parts {
+		parts[i] = regexp.QuoteMeta(p)
+	}
+	expr := "^" + strings.Join(parts, ".*")
+	if anchored {
+		expr += "$"
+	}
+	return regexp.MustCompile(expr)
+}
@@ -0,0 +1,157 @@
+package bookmarks
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Scheduler periodically checks every bookmark whose last check is older
+// than Interval. Bookmarks are claimed with a short lease before they are
+// checked, so several server instances can share one database without
+// checking the same bookmark twice.
+type Scheduler struct {
+	pool     *pgxpool.Pool
+	checker  *Checker
+	Interval time.Duration // how old a check may get before it is repeated
+	Poll     time.Duration // how often to look for stale bookmarks
+	Workers  int           // maximum concurrent checks
+	// Lease is how long a claimed bookmark is reserved for this instance.
+	// It is stretched if a batch could take longer to check.
+	Lease        time.Duration
+	CheckTimeout time.Duration // bounds each check, including retries and host delays
+	// ArchiveTimeout is how much longer a check may take to archive the
+	// page it fetched.
+	ArchiveTimeout time.Duration
+
+	wake chan struct{}
+}
+
+func NewScheduler(pool *pgxpool.Pool, checker *Checker, interval time.Duration, workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Scheduler{
+		pool:         pool,
+		checker:      checker,
+		Interval:     interval,
+		Poll:         time.Minute,
+		Workers:      workers,
+		Lease:        5 * time.Minute,
+		CheckTimeout: time.Minute,
+		wake:         make(chan struct{}, 1),
+	}
+}
+
+// Wake makes Run look for bookmarks to check now rather than at its next
+// poll, as when one has just been added. It never blocks.
+func (s *Scheduler) Wake() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run blocks until ctx is cancelled. Checks that are in flight when that
+// happens are allowed to finish before Run returns.
+func (s *Scheduler) Run(ctx context.Context) {
+	log.Printf("bookmark scheduler: checking every %s with %d workers", s.Interval, s.Workers)
+
+	ticker := time.NewTicker(s.Poll)
+	defer ticker.Stop()
+
+	for {
+		// Keep going while full batches come back, so a large backlog is
+		// drained without waiting for the next tick.
+		for {
+			n, err := s.runBatch(ctx)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("bookmark scheduler:", err)
+				}
+				break
+			}
+			if n < s.batchSize() {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Println("bookmark scheduler: stopped")
+			return
+		case <-ticker.C:
+		case <-s.wake:
+		}
+	}
+}
+
+func (s *Scheduler) batchSize() int {
+	return s.Workers * 4
+}
+
+// lease is how long a batch is claimed for: at least as long as its checks
+// can take. A worker runs batchSize/Workers checks in turn, and one more if
+// another worker is held up by a slow check.
+func (s *Scheduler) lease() time.Duration {
+	perWorker := (s.batchSize()+s.Workers-1)/s.Workers + 1
+	return max(s.Lease, time.Duration(perWorker)*(s.CheckTimeout+s.ArchiveTimeout)+time.Minute)
+}
+
+func (s *Scheduler) runBatch(ctx context.Context) (int, error) {
+	ids, err := ClaimDue(ctx, s.pool, s.Interval, s.lease(), s.batchSize())
+	if err != nil {
+		return 0, err
+	}
+
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for range s.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range jobs {
+				// Checks run on a context detached from shutdown so a
+				// check that has started is recorded rather than torn.
+				checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), s.CheckTimeout)
+				if err := s.check(checkCtx, id); err != nil {
+					log.Printf("bookmark scheduler: check %s: %v", id, err)
+				}
+				cancel()
+			}
+		}()
+	}
+
+	sent := 0
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- id
+		sent++
+	}
+	close(jobs)
+	wg.Wait()
+
+	// Bookmarks claimed but never started are handed back right away
+	// instead of waiting for their lease to expire.
+	if sent < len(ids) {
+		if err := ReleaseClaims(context.WithoutCancel(ctx), s.pool, ids[sent:]); err != nil {
+			log.Println("bookmark scheduler:", err)
+		}
+	}
+
+	return len(ids), ctx.Err()
+}
+
+func (s *Scheduler) check(ctx context.Context, id string) error {
+	_, bookmark, err := GetByID(ctx, s.pool, id)
+	if err != nil {
+		return err
+	}
+	_, err = s.checker.Check(ctx, bookmark)
+	return err
+}
@@ -0,0 +1,23 @@
+package bookmarks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedulerWake(t *testing.T) {
+	s := NewScheduler(nil, nil, time.Hour, 1)
+	s.Wake()
+	s.Wake() // must not block while a wake is pending
+
+	select {
+	case <-s.wake:
+	default:
+		t.Fatal("Wake left no signal")
+	}
+	select {
+	case <-s.wake:
+		t.Fatal("two wakes before Run looked left two signals")
+	default:
+	}
+}
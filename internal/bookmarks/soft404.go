@@ -0,0 +1,164 @@
+package bookmarks
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// soft404Threshold is the confidence from which a page is flagged.
+const soft404Threshold = 0.5
+
+// notFoundTitle matches titles that read like an error page. Real pages
+// can be titled like that too ("HTTP 404 explained"), so a title only
+// counts along with another signal.
+var notFoundTitle = regexp.MustCompile(`(?i)\b(404|not found|page (does not|doesn't|does ?n.t) exist|no longer (available|exists)|page (is )?unavailable|nothing (was )?found|page missing|page (is )?gone)\b`)
+
+// soft404Probe remembers what a host serves for a path that can't exist.
+type soft404Probe struct {
+	text      *string // nil when the host answered with a real error
+	fetchedAt time.Time
+}
+
+type soft404Probes struct {
+	mu    sync.Mutex
+	hosts map[string]soft404Probe
+}
+
+// detectSoft404 estimates how likely it is that a successful response is
+// really a "not found" page. It combines three signals: a redirect from a
+// deep link to the site root, a title that reads like an error page, and a
+// body that matches what the host serves for a random nonexistent path.
+func (c *Checker) detectSoft404(ctx context.Context, client *http.Client, b Bookmark, result CheckResult, body []byte) float64 {
+	orig, err := url.Parse(b.URL)
+	if err != nil || isRoot(orig) || result.Snapshot == nil {
+		return 0
+	}
+
+	var signals []float64
+
+	if len(result.Redirects) > 0 {
+		if final, err := url.Parse(result.FinalURL); err == nil && isRoot(final) {
+			signals = append(signals, 0.6)
+		}
+	}
+
+	if notFoundTitle.MatchString(pageTitle(body)) {
+		signals = append(signals, 0.35)
+	}
+
+	if probe := c.probeNotFound(ctx, client, orig); probe != nil {
+		switch {
+		case *probe == *result.Snapshot:
+			signals = append(signals, 0.95)
+		case similarity(*probe, *result.Snapshot) >= 0.9:
+			signals = append(signals, 0.85)
+		}
+	}
+
+	// Treat the signals as independent: the page is a real page only if
+	// every signal is a false alarm.
+	genuine := 1.0
+	for _, s := range signals {
+		genuine *= 1 - s
+	}
+	return 1 - genuine
+}
+
+func isRoot(u *url.URL) bool {
+	return (u.Path == "" || u.Path == "/") && u.RawQuery == ""
+}
+
+// probeNotFound fetches a random path on u's host and returns its
+// normalized text, or nil if the host answers such paths with an error.
+// Results are cached per host for a day.
+func (c *Checker) probeNotFound(ctx context.Context, client *http.Client, u *url.URL) *string {
+	site := u.Scheme + "://" + u.Host
+
+	c.probes.mu.Lock()
+	probe, ok := c.probes.hosts[site]
+	c.probes.mu.Unlock()
+	if ok && c.clock().Now().Sub(probe.fetchedAt) < 24*time.Hour {
+		return probe.text
+	}
+
+	probe = soft404Probe{fetchedAt: c.clock().Now()}
+	nonce := make([]byte, 12)
+	rand.Read(nonce)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+		site+"/cairn-probe-"+hex.EncodeToString(nonce), nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// Don't cache network failures; the next check tries again.
+		return nil
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+		if text, ok := NormalizeContent(resp.Header.Get("Content-Type"), body); ok {
+			probe.text = &text
+		}
+	}
+
+	c.probes.mu.Lock()
+	if c.probes.hosts == nil {
+		c.probes.hosts = make(map[string]soft404Probe)
+	}
+	c.probes.hosts[site] = probe
+	c.probes.mu.Unlock()
+	return probe.text
+}
+
+// similarity is the Jaccard index of the word sets of a and b.
+func similarity(a, b string) float64 {
+	wa, wb := wordSet(a), wordSet(b)
+	if len(wa) == 0 && len(wb) == 0 {
+		return 1
+	}
+	shared := 0
+	for w := range wa {
+		if wb[w] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(wa)+len(wb)-shared)
+}
+
+func wordSet(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(strings.ToLower(s)) {
+		set[w] = true
+	}
+	return set
+}
+
+// pageTitle returns the text of the first <title> element in an HTML body.
+func pageTitle(body []byte) string {
+	z := html.NewTokenizer(bytes.NewReader(body))
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			return ""
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if atom.Lookup(name) == atom.Title && z.Next() == html.TextToken {
+				return strings.TrimSpace(string(z.Text()))
+			}
+		}
+	}
+}
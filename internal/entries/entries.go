@@ -2,8 +2,10 @@ package entries
 
 import (
 	"context"
+	"errors"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -13,13 +15,31 @@ type Entry struct {
 	Title     string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+	// Version goes up by one with every save. Edit forms send back the
+	// version they were loaded at, so a stale save can be refused.
+	Version int
+	// FaviconURL and Description are set for bookmarks whose page has been
+	// fetched.
+	FaviconURL  *string
+	Description *string
+}
+
+// ErrConflict is returned when saving an entry that was changed since the
+// version being saved was loaded.
+var ErrConflict = errors.New("entry was changed since it was loaded")
+
+// Path is where the entry is viewed.
+func (e Entry) Path() string {
+	return "/" + e.EntryType + "s/" + e.ID
 }
 
 func ListAll(ctx context.Context, pool *pgxpool.Pool) ([]Entry, error) {
 	rows, err := pool.Query(ctx,
-		`SELECT id, entry_type, title, created_at, updated_at
-         FROM entries
-         ORDER BY updated_at DESC`)
+		`SELECT e.id, e.entry_type, e.title, e.created_at, e.updated_at,
+                b.favicon_url, b.description
+         FROM entries e
+         LEFT JOIN bookmarks b ON b.entry_id = e.id
+         ORDER BY e.updated_at DESC`)
 	if err != nil {
 		return nil, err
 	}
@@ -28,7 +48,8 @@ func ListAll(ctx context.Context, pool *pgxpool.Pool) ([]Entry, error) {
 	var entries []Entry
 	for rows.Next() {
 		var e Entry
-		err := rows.Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt)
+		err := rows.Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt,
+			&e.FaviconURL, &e.Description)
 		if err != nil {
 			return nil, err
 		}
@@ -36,3 +57,96 @@ func ListAll(ctx context.Context, pool *pgxpool.Pool) ([]Entry, error) {
 	}
 	return entries, rows.Err()
 }
+
+// SetTags replaces the tags of an entry with names, creating any tags that
+// don't exist yet. It runs in tx so that it commits with the entry itself.
+func SetTags(ctx context.Context, tx pgx.Tx, entryID string, names []string) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO tags (name) SELECT unnest($1::text[]) ON CONFLICT (name) DO NOTHING`,
+		names,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM entry_tags WHERE entry_id = $1`, entryID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO entry_tags (entry_id, tag_id)
+         SELECT $1::uuid, id FROM tags WHERE name = ANY($2)`,
+		entryID, names,
+	)
+	return err
+}
+
+func GetTags(ctx context.Context, pool *pgxpool.Pool, entryID string) ([]string, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT t.name
+         FROM tags t
+         JOIN entry_tags et ON et.tag_id = t.id
+         WHERE et.entry_id = $1
+         ORDER BY t.name`,
+		entryID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tags []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+	return tags, rows.Err()
+}
+
+// UpdateTitle saves the title of an entry loaded at version and moves it to
+// the next version. If the title changed, the notes that link to it by
+// title are relinked. It returns ErrConflict if the entry has been saved
+// since.
+func UpdateTitle(ctx context.Context, tx pgx.Tx, id, title string, version int) error {
+	var oldTitle string
+	err := tx.QueryRow(ctx,
+		`UPDATE entries e SET title = $1, updated_at = now(), version = e.version + 1
+         FROM entries old
+         WHERE e.id = $2 AND e.version = $3 AND old.id = e.id
+         RETURNING old.title`,
+		title, id, version,
+	).Scan(&oldTitle)
+	if err == nil {
+		if oldTitle == title {
+			return nil
+		}
+		return LinkTitle(ctx, tx, id, title)
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return err
+	}
+
+	var exists bool
+	err = tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM entries WHERE id = $1)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return pgx.ErrNoRows
+	}
+	return ErrConflict
+}
+
+// ConflictField is one field of an entry on the page shown when a save is
+// refused: what the user tried to save next to what is saved now.
+type ConflictField struct {
+	Name      string
+	Label     string
+	Mine      string
+	Current   string
+	Multiline bool
+}
@@ -0,0 +1,166 @@
+package entries
+
+import (
+	"context"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/markdown"
+)
+
+// Querier is what both a pool and a transaction offer for reading rows.
+type Querier interface {
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+}
+
+// Resolve looks up the entries that link references point at. A reference
+// is an entry ID or, ignoring case, a title; when several entries share a
+// title the most recently updated wins. References that match nothing are
+// left out of the result.
+func Resolve(ctx context.Context, q Querier, refs []string) (map[string]Entry, error) {
+	resolved := map[string]Entry{}
+	if len(refs) == 0 {
+		return resolved, nil
+	}
+
+	rows, err := q.Query(ctx,
+		`SELECT DISTINCT ON (r.ref) r.ref, e.id, e.entry_type, e.title, e.created_at, e.updated_at
+         FROM unnest($1::text[]) AS r(ref)
+         JOIN entries e ON e.id::text = lower(r.ref) OR lower(e.title) = lower(r.ref)
+         ORDER BY r.ref, e.id::text = lower(r.ref) DESC, e.updated_at DESC`,
+		refs,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ref string
+		var e Entry
+		if err := rows.Scan(&ref, &e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		resolved[ref] = e
+	}
+	return resolved, rows.Err()
+}
+
+// SyncLinks makes the outgoing links of sourceID exactly the entries refs
+// resolve to. References to nothing or to the source itself are dropped.
+func SyncLinks(ctx context.Context, tx pgx.Tx, sourceID string, refs []string) error {
+	resolved, err := Resolve(ctx, tx, refs)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx, `DELETE FROM entry_links WHERE source_id = $1`, sourceID)
+	if err != nil {
+		return err
+	}
+
+	for _, target := range resolved {
+		if target.ID == sourceID {
+			continue
+		}
+		_, err := tx.Exec(ctx,
+			`INSERT INTO entry_links (source_id, target_id) VALUES ($1, $2)
+             ON CONFLICT DO NOTHING`,
+			sourceID, target.ID,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LinkTitle brings other notes' links up to date with the entry id being
+// saved under title: notes that linked to it by an old title let go of it,
+// and notes whose [[title]] links were waiting for it are linked to it.
+func LinkTitle(ctx context.Context, tx pgx.Tx, id, title string) error {
+	// A cheap text match finds the waiting notes; only notes whose links
+	// really resolve to id end up linked.
+	return relink(ctx, tx,
+		`SELECT entry_id, body FROM notes
+         WHERE entry_id <> $1
+           AND (entry_id IN (SELECT source_id FROM entry_links WHERE target_id = $1)
+             OR strpos(lower(body), lower('[[' || $2 || ']]')) > 0
+             OR strpos(lower(body), lower('[[' || $2 || '|')) > 0)`,
+		id, title,
+	)
+}
+
+// LinkTitles links up notes whose [[title]] links were waiting for any of
+// titles, the titles of entries just created, in a single pass over the
+// notes. It is for creating many entries at once, as an import does.
+func LinkTitles(ctx context.Context, tx pgx.Tx, titles []string) error {
+	if len(titles) == 0 {
+		return nil
+	}
+	return relink(ctx, tx,
+		`SELECT entry_id, body FROM notes
+         WHERE EXISTS (
+             SELECT 1 FROM unnest($1::text[]) AS t(title)
+             WHERE strpos(lower(body), lower('[[' || t.title || ']]')) > 0
+                OR strpos(lower(body), lower('[[' || t.title || '|')) > 0
+         )`,
+		titles,
+	)
+}
+
+// relink syncs the links of the notes sql selects, as entry_id and body.
+func relink(ctx context.Context, tx pgx.Tx, sql string, args ...any) error {
+	rows, err := tx.Query(ctx, sql, args...)
+	if err != nil {
+		return err
+	}
+	type source struct{ id, body string }
+	var sources []source
+	for rows.Next() {
+		var s source
+		if err := rows.Scan(&s.id, &s.body); err != nil {
+			rows.Close()
+			return err
+		}
+		sources = append(sources, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, s := range sources {
+		if err := SyncLinks(ctx, tx, s.id, markdown.WikiLinks(s.body)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Backlinks returns the entries that link to id, most recently updated
+// first.
+func Backlinks(ctx context.Context, pool *pgxpool.Pool, id string) ([]Entry, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT e.id, e.entry_type, e.title, e.created_at, e.updated_at
+         FROM entry_links l
+         JOIN entries e ON e.id = l.source_id
+         WHERE l.target_id = $1
+         ORDER BY e.updated_at DESC`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []Entry
+	for rows.Next() {
+		var e Entry
+		if err := rows.Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
@@ -0,0 +1,154 @@
+package entries
+
+import (
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/database"
+)
+
+// testPool connects to the database in DATABASE_URL and brings it up to
+// date, or skips the test if none is given.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	pool, err := database.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	if err := database.RunMigrations(ctx, pool, "../../migrations"); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// testEntry makes an entry of entryType titled title, updated ago before
+// now, and returns its ID.
+func testEntry(t *testing.T, pool *pgxpool.Pool, entryType, title, ago string) string {
+	t.Helper()
+	var id string
+	err := pool.QueryRow(context.Background(),
+		`INSERT INTO entries (entry_type, title, updated_at)
+         VALUES ($1, $2, now() - $3::interval) RETURNING id`,
+		entryType, title, ago,
+	).Scan(&id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		pool.Exec(context.Background(), `DELETE FROM entries WHERE id = $1`, id)
+	})
+	return id
+}
+
+func TestResolve(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	// Titles are made unique to the run so other rows can't match them.
+	suffix := " " + testEntry(t, pool, "note", "resolve test", "0s")[:8]
+	reading := testEntry(t, pool, "note", "Reading List"+suffix, "1 hour")
+	testEntry(t, pool, "note", "Shared"+suffix, "2 hours")
+	newShared := testEntry(t, pool, "bookmark", "shared"+suffix, "1 hour")
+
+	tests := []struct {
+		ref  string
+		want string // entry ID, or "" for unresolved
+	}{
+		{ref: "Reading List" + suffix, want: reading},
+		{ref: "reading list" + suffix, want: reading},
+		{ref: "READING LIST" + suffix, want: reading},
+		{ref: reading, want: reading},
+		{ref: strings.ToUpper(reading), want: reading},
+		{ref: "Shared" + suffix, want: newShared},
+		{ref: "Reading" + suffix},
+		{ref: "Reading List" + suffix + " 2"},
+	}
+	refs := make([]string, len(tests))
+	for i, tt := range tests {
+		refs[i] = tt.ref
+	}
+	resolved, err := Resolve(ctx, pool, refs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range tests {
+		got, ok := resolved[tt.ref]
+		switch {
+		case tt.want == "" && ok:
+			t.Errorf("Resolve(%q) = %s, want nothing", tt.ref, got.ID)
+		case tt.want != "" && got.ID != tt.want:
+			t.Errorf("Resolve(%q) = %q, want %s", tt.ref, got.ID, tt.want)
+		}
+	}
+}
+
+func TestSyncLinks(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	source := testEntry(t, pool, "note", "sync test", "0s")
+	suffix := " " + source[:8]
+	a := testEntry(t, pool, "note", "Alpha"+suffix, "0s")
+	b := testEntry(t, pool, "bookmark", "Beta"+suffix, "0s")
+
+	sync := func(refs ...string) []string {
+		t.Helper()
+		tx, err := pool.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback(ctx)
+		if err := SyncLinks(ctx, tx, source, refs); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		var targets []string
+		rows, err := pool.Query(ctx, `SELECT target_id FROM entry_links WHERE source_id = $1`, source)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				t.Fatal(err)
+			}
+			targets = append(targets, id)
+		}
+		slices.Sort(targets)
+		return targets
+	}
+	sorted := func(ids ...string) []string {
+		slices.Sort(ids)
+		return ids
+	}
+
+	tests := []struct {
+		name string
+		refs []string
+		want []string
+	}{
+		{name: "by title", refs: []string{"Alpha" + suffix}, want: sorted(a)},
+		{name: "by title and id", refs: []string{"alpha" + suffix, b}, want: sorted(a, b)},
+		{name: "same target twice", refs: []string{"Alpha" + suffix, a}, want: sorted(a)},
+		{name: "itself and nothing", refs: []string{source, "Gamma" + suffix}},
+		{name: "dropped", refs: nil},
+	}
+	for _, tt := range tests {
+		if got := sync(tt.refs...); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: links = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Package markdown renders user-written Markdown to HTML that is safe to
+// embed in pages.
+package markdown
+
+import (
+	"bytes"
+	"container/list"
+	"crypto/sha256"
+	"html/template"
+	"regexp"
+	"sort"
+	"sync"
+
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	highlighting "github.com/yuin/goldmark-highlighting/v2"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/text"
+)
+
+// Renderer turns CommonMark with GitHub extensions (tables, task lists,
+// strikethrough, autolinks) and [[wiki links]] into sanitized HTML. Fenced
+// code blocks are highlighted with CSS classes; see static/highlight.css.
+// Rendered bodies are cached by content, so an unchanged note isn't parsed
+// again.
+type Renderer struct {
+	md     goldmark.Markdown
+	policy *bluemonday.Policy
+
+	html  *lru[template.HTML]
+	links *lru[[]string]
+}
+
+// New returns a Renderer that keeps up to capacity rendered bodies.
+func New(capacity int) *Renderer {
+	md := goldmark.New(
+		goldmark.WithExtensions(
+			extension.GFM,
+			wikiLinks{},
+			highlighting.NewHighlighting(
+				highlighting.WithFormatOptions(chromahtml.WithClasses(true)),
+			),
+		),
+	)
+
+	// Goldmark escapes raw HTML on its own, but the sanitizer is what makes
+	// the output safe whatever the renderer's options are.
+	policy := bluemonday.UGCPolicy()
+	policy.AllowAttrs("class").Matching(regexp.MustCompile(`^[a-z0-9 -]+$`)).OnElements("pre", "code", "span", "a")
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").OnElements("input")
+	policy.AllowStyles("text-align").MatchingEnum("left", "center", "right").OnElements("th", "td")
+
+	return &Renderer{
+		md:     md,
+		policy: policy,
+		html:   newLRU[template.HTML](capacity),
+		links:  newLRU[[]string](capacity),
+	}
+}
+
+// Links returns the targets of the wiki links in src, like WikiLinks, but
+// cached.
+func (r *Renderer) Links(src string) []string {
+	key := sha256.Sum256([]byte(src))
+	if targets, ok := r.links.get(key); ok {
+		return targets
+	}
+	targets := WikiLinks(src)
+	r.links.put(key, targets)
+	return targets
+}
+
+// Render returns src as HTML, with each wiki link pointing where links
+// says. Wiki links missing from links are shown as plain text. A body that
+// fails to render is shown as escaped text.
+func (r *Renderer) Render(src string, links map[string]Link) template.HTML {
+	key := renderKey(src, links)
+	if html, ok := r.html.get(key); ok {
+		return html
+	}
+
+	source := []byte(src)
+	doc := r.md.Parser().Parse(text.NewReader(source))
+	walkWikiLinks(doc, func(n *wikiLinkNode) {
+		if link, ok := links[n.Target]; ok {
+			n.Link = &link
+		}
+	})
+
+	var buf bytes.Buffer
+	if err := r.md.Renderer().Render(&buf, source, doc); err != nil {
+		return template.HTML("<pre>" + template.HTMLEscapeString(src) + "</pre>")
+	}
+	html := template.HTML(r.policy.SanitizeBytes(buf.Bytes()))
+	r.html.put(key, html)
+	return html
+}
+
+// renderKey identifies a rendering: the same body renders differently once
+// its links resolve elsewhere.
+func renderKey(src string, links map[string]Link) [sha256.Size]byte {
+	h := sha256.New()
+	h.Write([]byte(src))
+
+	targets := make([]string, 0, len(links))
+	for t := range links {
+		targets = append(targets, t)
+	}
+	sort.Strings(targets)
+	for _, t := range targets {
+		link := links[t]
+		h.Write([]byte{0})
+		h.Write([]byte(t))
+		h.Write([]byte{0})
+		h.Write([]byte(link.Href))
+		if link.Missing {
+			h.Write([]byte{1})
+		}
+	}
+
+	var key [sha256.Size]byte
+	h.Sum(key[:0])
+	return key
+}
+
+// lru is a fixed-size cache that drops the least recently used value.
+type lru[V any] struct {
+	mu       sync.Mutex
+	capacity int
+	order    *list.List // most recently used first
+	items    map[[sha256.Size]byte]*list.Element
+}
+
+type lruItem[V any] struct {
+	key   [sha256.Size]byte
+	value V
+}
+
+func newLRU[V any](capacity int) *lru[V] {
+	return &lru[V]{
+		capacity: capacity,
+		order:    list.New(),
+		items:    map[[sha256.Size]byte]*list.Element{},
+	}
+}
+
+func (c *lru[V]) get(key [sha256.Size]byte) (V, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[key]
+	if !ok {
+		var zero V
+		return zero, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruItem[V]).value, true
+}
+
+func (c *lru[V]) put(key [sha256.Size]byte, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = c.order.PushFront(&lruItem[V]{key: key, value: value})
+	for c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*lruItem[V]).key)
+	}
+}
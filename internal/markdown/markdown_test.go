@@ -0,0 +1,132 @@
+package markdown
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderSanitizes(t *testing.T) {
+	tests := []struct {
+		src  string
+		bad  []string // must not appear in the output
+		want []string // must appear in the output
+	}{
+		{src: "<script>alert(1)</script>", bad: []string{"<script", "alert(1)"}},
+		{src: "before <script>alert(1)</script> after", bad: []string{"<script"}, want: []string{"before", "after"}},
+		{src: "[click](javascript:alert(1))", bad: []string{"javascript:"}, want: []string{"click"}},
+		{src: "[click](JavaScript:alert(1))", bad: []string{"avascript:"}, want: []string{"click"}},
+		{src: `<a href="javascript:alert(1)">click</a>`, bad: []string{"javascript:"}},
+		{src: "<img src=x onerror=alert(1)>", bad: []string{"onerror"}},
+		{src: "[[javascript:alert(1)]]", bad: []string{"href"}, want: []string{"javascript:alert(1)"}},
+		{src: "[ok](https://example.com)", want: []string{`href="https://example.com"`}},
+	}
+	r := New(16)
+	for _, tt := range tests {
+		got := string(r.Render(tt.src, nil))
+		for _, s := range tt.bad {
+			if strings.Contains(got, s) {
+				t.Errorf("Render(%q) = %q, contains %q", tt.src, got, s)
+			}
+		}
+		for _, s := range tt.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("Render(%q) = %q, missing %q", tt.src, got, s)
+			}
+		}
+	}
+}
+
+// Goldmark drops raw HTML before the sanitizer sees it, so the policy is
+// also checked on its own.
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{html: `<p>hi<script>alert(1)</script></p>`, want: `<p>hi</p>`},
+		{html: `<a href="javascript:alert(1)">x</a>`, want: `x`},
+		{html: `<a href="https://example.com" onclick="alert(1)">x</a>`, want: `<a href="https://example.com" rel="nofollow">x</a>`},
+		{html: `<span class="kd" style="color: red">func</span>`, want: `<span class="kd">func</span>`},
+		{html: `<span class="a&quot;b">x</span>`, want: `<span>x</span>`},
+		{html: `<input type="text" value="x">`, want: ``},
+		{html: `<input type="checkbox" checked disabled>`, want: `<input type="checkbox" checked="" disabled="">`},
+		{html: `<td style="text-align: center">x</td>`, want: `<td style="text-align: center">x</td>`},
+		{html: `<td style="position: fixed">x</td>`, want: `<td>x</td>`},
+	}
+	r := New(16)
+	for _, tt := range tests {
+		if got := r.policy.Sanitize(tt.html); got != tt.want {
+			t.Errorf("Sanitize(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+func TestRenderHighlighting(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{
+			src:  "```go\nfunc main() {}\n```",
+			want: []string{`<pre class="chroma">`, `<span class="kd">func</span>`, `<span class="nf">main</span>`},
+		},
+		{
+			src:  "```python\ndef f():\n    return 1\n```",
+			want: []string{`<pre class="chroma">`, `<span class="k">def</span>`, `<span class="mi">1</span>`},
+		},
+		{
+			src:  "```\nplain <b>text</b>\n```",
+			want: []string{`<pre><code>plain &lt;b&gt;text&lt;/b&gt;`},
+		},
+	}
+	r := New(16)
+	for _, tt := range tests {
+		got := string(r.Render(tt.src, nil))
+		for _, s := range tt.want {
+			if !strings.Contains(got, s) {
+				t.Errorf("Render(%q) = %q, missing %q", tt.src, got, s)
+			}
+		}
+	}
+}
+
+func TestRenderKey(t *testing.T) {
+	const src = "See [[Foo]]."
+	base := map[string]Link{"Foo": {Href: "/notes/1"}}
+	tests := []struct {
+		name  string
+		src   string
+		links map[string]Link
+		same  bool
+	}{
+		{name: "same links", src: src, links: map[string]Link{"Foo": {Href: "/notes/1"}}, same: true},
+		{name: "other body", src: "See [[Foo]]!", links: base},
+		{name: "no links", src: src, links: nil},
+		{name: "other target", src: src, links: map[string]Link{"Foo": {Href: "/notes/2"}}},
+		{name: "now missing", src: src, links: map[string]Link{"Foo": {Href: "/notes/1", Missing: true}}},
+		{name: "extra link", src: src, links: map[string]Link{"Foo": {Href: "/notes/1"}, "Bar": {Href: "/notes/3"}}},
+		{name: "renamed ref", src: src, links: map[string]Link{"foo": {Href: "/notes/1"}}},
+		// The separators keep one reference from running into the next.
+		{name: "shifted boundary", src: src, links: map[string]Link{"Foo/": {Href: "notes/1"}}},
+	}
+	want := renderKey(src, base)
+	for _, tt := range tests {
+		got := renderKey(tt.src, tt.links)
+		if (got == want) != tt.same {
+			t.Errorf("%s: key equal = %v, want %v", tt.name, got == want, tt.same)
+		}
+	}
+}
+
+func TestRenderCachedByLinks(t *testing.T) {
+	r := New(16)
+	const src = "See [[Foo]]."
+	missing := string(r.Render(src, map[string]Link{"Foo": {Href: "/notes/new?title=Foo", Missing: true}}))
+	found := string(r.Render(src, map[string]Link{"Foo": {Href: "/notes/1"}}))
+	if missing == found {
+		t.Fatalf("Render gave %q for both link sets", found)
+	}
+	if !strings.Contains(found, `href="/notes/1"`) {
+		t.Errorf("Render = %q, want a link to /notes/1", found)
+	}
+}
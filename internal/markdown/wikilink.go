@@ -0,0 +1,139 @@
+package markdown
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Link is where a [[wiki link]] points. Missing links point somewhere the
+// target can be created.
+type Link struct {
+	Href    string
+	Missing bool
+}
+
+// wikiLinkNode is a [[target]] or [[target|label]] reference.
+type wikiLinkNode struct {
+	ast.BaseInline
+	Target string
+	Label  string
+	Link   *Link
+}
+
+var kindWikiLink = ast.NewNodeKind("WikiLink")
+
+func (n *wikiLinkNode) Kind() ast.NodeKind { return kindWikiLink }
+
+func (n *wikiLinkNode) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Target": n.Target}, nil)
+}
+
+type wikiLinkParser struct{}
+
+func (wikiLinkParser) Trigger() []byte { return []byte{'['} }
+
+func (wikiLinkParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	if !bytes.HasPrefix(line, []byte("[[")) {
+		return nil
+	}
+	end := bytes.Index(line[2:], []byte("]]"))
+	if end < 0 {
+		return nil
+	}
+	inner := line[2 : 2+end]
+	if bytes.ContainsAny(inner, "[]") {
+		return nil
+	}
+
+	target, label := inner, inner
+	if i := bytes.IndexByte(inner, '|'); i >= 0 {
+		target, label = inner[:i], inner[i+1:]
+	}
+	target = bytes.TrimSpace(target)
+	label = bytes.TrimSpace(label)
+	if len(target) == 0 {
+		return nil
+	}
+	if len(label) == 0 {
+		label = target
+	}
+
+	block.Advance(end + 4)
+	return &wikiLinkNode{Target: string(target), Label: string(label)}
+}
+
+type wikiLinkRenderer struct{}
+
+func (wikiLinkRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(kindWikiLink, renderWikiLink)
+}
+
+func renderWikiLink(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*wikiLinkNode)
+	if n.Link == nil {
+		w.WriteString(`<span class="wikilink">`)
+		w.Write(util.EscapeHTML([]byte(n.Label)))
+		w.WriteString(`</span>`)
+		return ast.WalkSkipChildren, nil
+	}
+
+	w.WriteString(`<a class="wikilink`)
+	if n.Link.Missing {
+		w.WriteString(` wikilink-missing" title="Create this note`)
+	}
+	w.WriteString(`" href="`)
+	w.Write(util.EscapeHTML(util.URLEscape([]byte(n.Link.Href), true)))
+	w.WriteString(`">`)
+	w.Write(util.EscapeHTML([]byte(n.Label)))
+	w.WriteString(`</a>`)
+	return ast.WalkSkipChildren, nil
+}
+
+type wikiLinks struct{}
+
+func (wikiLinks) Extend(m goldmark.Markdown) {
+	// Ahead of the standard link parser, which also starts at '['.
+	m.Parser().AddOptions(parser.WithInlineParsers(
+		util.Prioritized(wikiLinkParser{}, 199),
+	))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(
+		util.Prioritized(wikiLinkRenderer{}, 500),
+	))
+}
+
+var linkParser = goldmark.New(goldmark.WithExtensions(extension.GFM, wikiLinks{}))
+
+// WikiLinks returns the targets of the [[wiki links]] in src, in order of
+// first appearance. Links inside code are not links.
+func WikiLinks(src string) []string {
+	doc := linkParser.Parser().Parse(text.NewReader([]byte(src)))
+	var targets []string
+	seen := map[string]bool{}
+	walkWikiLinks(doc, func(n *wikiLinkNode) {
+		if !seen[n.Target] {
+			seen[n.Target] = true
+			targets = append(targets, n.Target)
+		}
+	})
+	return targets
+}
+
+func walkWikiLinks(doc ast.Node, f func(n *wikiLinkNode)) {
+	ast.Walk(doc, func(n ast.Node, entering bool) (ast.WalkStatus, error) {
+		if link, ok := n.(*wikiLinkNode); ok && entering {
+			f(link)
+		}
+		return ast.WalkContinue, nil
+	})
+}
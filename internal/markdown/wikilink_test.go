@@ -0,0 +1,75 @@
+package markdown
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestWikiLinks(t *testing.T) {
+	tests := []struct {
+		src  string
+		want []string
+	}{
+		{src: "See [[Reading list]].", want: []string{"Reading list"}},
+		{src: "See [[0b6f5b1e-3c1a-4c8e-9d2f-7a0e5c4b3a21]].", want: []string{"0b6f5b1e-3c1a-4c8e-9d2f-7a0e5c4b3a21"}},
+		{src: "See [[Reading list|my books]].", want: []string{"Reading list"}},
+		{src: "See [[ Reading list | my books ]].", want: []string{"Reading list"}},
+		{src: "See [[Reading list|]].", want: []string{"Reading list"}},
+		{src: "[[A]], [[B]] and [[A|again]]", want: []string{"A", "B"}},
+		{src: "[[a]] and [[A]]", want: []string{"a", "A"}},
+		{src: "- [[In a list]]\n\n> [[In a quote]]", want: []string{"In a list", "In a quote"}},
+		{src: "**[[Bold]]**", want: []string{"Bold"}},
+
+		// Things that only look like wiki links aren't.
+		{src: "`[[In code]]`"},
+		{src: "```\n[[In a block]]\n```"},
+		{src: "[[Unclosed"},
+		{src: "[[]]"},
+		{src: "[[|label]]"},
+		{src: "[[  ]]"},
+		{src: "[[a [b] c]]"},
+		{src: "[[one\ntwo]]"},
+		{src: "[a link](https://example.com)"},
+		{src: ""},
+	}
+	for _, tt := range tests {
+		if got := WikiLinks(tt.src); !slices.Equal(got, tt.want) {
+			t.Errorf("WikiLinks(%q) = %q, want %q", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestRenderWikiLinks(t *testing.T) {
+	links := map[string]Link{
+		"Reading list":                         {Href: "/notes/1"},
+		"0b6f5b1e-3c1a-4c8e-9d2f-7a0e5c4b3a21": {Href: "/bookmarks/0b6f5b1e-3c1a-4c8e-9d2f-7a0e5c4b3a21"},
+		"Someday":                              {Href: "/notes/new?title=Someday", Missing: true},
+		"Tom & Jerry":                          {Href: "/notes/2"},
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{src: "[[Reading list]]", want: `<p><a class="wikilink" href="/notes/1" rel="nofollow">Reading list</a></p>`},
+		{src: "[[Reading list|my books]]", want: `<p><a class="wikilink" href="/notes/1" rel="nofollow">my books</a></p>`},
+		{src: "[[0b6f5b1e-3c1a-4c8e-9d2f-7a0e5c4b3a21|a page]]",
+			want: `<p><a class="wikilink" href="/bookmarks/0b6f5b1e-3c1a-4c8e-9d2f-7a0e5c4b3a21" rel="nofollow">a page</a></p>`},
+		{src: "[[Someday]]",
+			want: `<p><a class="wikilink wikilink-missing" title="Create this note" href="/notes/new?title=Someday" rel="nofollow">Someday</a></p>`},
+		{src: "[[Someday|later]]",
+			want: `<p><a class="wikilink wikilink-missing" title="Create this note" href="/notes/new?title=Someday" rel="nofollow">later</a></p>`},
+		{src: "[[Tom & Jerry|<b>cartoon</b>]]", want: `<p><a class="wikilink" href="/notes/2" rel="nofollow">&lt;b&gt;cartoon&lt;/b&gt;</a></p>`},
+		// Links the caller didn't resolve at all are shown as text.
+		{src: "[[Elsewhere]]", want: `<p><span class="wikilink">Elsewhere</span></p>`},
+		{src: "[[Elsewhere|over there]]", want: `<p><span class="wikilink">over there</span></p>`},
+		{src: "`[[Reading list]]`", want: `<p><code>[[Reading list]]</code></p>`},
+	}
+	r := New(16)
+	for _, tt := range tests {
+		got := strings.TrimSpace(string(r.Render(tt.src, links)))
+		if got != tt.want {
+			t.Errorf("Render(%q) =\n\t%s\nwant\n\t%s", tt.src, got, tt.want)
+		}
+	}
+}
@@ -1,21 +1,32 @@
 package notes
 
 import (
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/attachments"
+	"github.com/nemouu/cairn/internal/diff"
+	"github.com/nemouu/cairn/internal/entries"
+	"github.com/nemouu/cairn/internal/markdown"
 )
 
-func RegisterRoutes(mux *http.ServeMux, pool *pgxpool.Pool) {
+func RegisterRoutes(mux *http.ServeMux, pool *pgxpool.Pool, md *markdown.Renderer) {
 	mux.HandleFunc("GET /notes/new", handleForm(pool, false))
 	mux.HandleFunc("POST /notes", handleCreate(pool))
-	mux.HandleFunc("GET /notes/{id}", handleView(pool))
+	mux.HandleFunc("GET /notes/{id}", handleView(pool, md))
 	mux.HandleFunc("GET /notes/{id}/edit", handleForm(pool, true))
 	mux.HandleFunc("POST /notes/{id}", handleUpdate(pool))
 	mux.HandleFunc("POST /notes/{id}/delete", handleDelete(pool))
+	mux.HandleFunc("GET /notes/{id}/history", handleHistory(pool))
+	mux.HandleFunc("GET /notes/{id}/history/diff", handleRevisionDiff(pool))
+	mux.HandleFunc("POST /notes/{id}/history/{revision}/restore", handleRestore(pool))
 }
 
 func handleForm(pool *pgxpool.Pool, isEdit bool) http.HandlerFunc {
@@ -35,6 +46,9 @@ func handleForm(pool *pgxpool.Pool, isEdit bool) http.HandlerFunc {
 			data["Title"] = "Edit – " + entry.Title
 			data["Entry"] = entry
 			data["Note"] = note
+		} else if title := r.URL.Query().Get("title"); title != "" {
+			// Following a link to a note that doesn't exist yet.
+			data["Entry"] = entries.Entry{Title: title}
 		}
 
 		tmpl, err := template.ParseFiles("templates/layout.html", "internal/notes/templates/form.html")
@@ -73,7 +87,7 @@ func handleCreate(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-func handleView(pool *pgxpool.Pool) http.HandlerFunc {
+func handleView(pool *pgxpool.Pool, md *markdown.Renderer) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 
@@ -83,16 +97,46 @@ func handleView(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		tmpl, err := template.ParseFiles("templates/layout.html", "internal/notes/templates/view.html")
+		refs := md.Links(note.Body)
+		resolved, err := entries.Resolve(r.Context(), pool, refs)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		links := map[string]markdown.Link{}
+		for _, ref := range refs {
+			if target, ok := resolved[ref]; ok {
+				links[ref] = markdown.Link{Href: target.Path()}
+			} else {
+				links[ref] = markdown.Link{Href: "/notes/new?title=" + url.QueryEscape(ref), Missing: true}
+			}
+		}
+
+		backlinks, err := entries.Backlinks(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		files, err := attachments.List(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "templates/backlinks.html", "templates/attachments.html", "internal/notes/templates/view.html")
 		if err != nil {
 			http.Error(w, "template error", http.StatusInternalServerError)
 			return
 		}
 
 		data := map[string]any{
-			"Title": entry.Title,
-			"Entry": entry,
-			"Note":  note,
+			"Title":       entry.Title,
+			"Entry":       entry,
+			"Note":        note,
+			"Body":        md.Render(note.Body, links),
+			"Backlinks":   backlinks,
+			"Attachments": files,
 		}
 		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
 			log.Println("template render error:", err)
@@ -111,13 +155,27 @@ func handleUpdate(pool *pgxpool.Pool) http.HandlerFunc {
 
 		title := strings.TrimSpace(r.FormValue("title"))
 		body := r.FormValue("body")
+		version, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
 
 		if title == "" {
 			http.Error(w, "title is required", http.StatusBadRequest)
 			return
 		}
 
-		if err := Update(r.Context(), pool, id, title, body); err != nil {
+		err = Update(r.Context(), pool, id, title, body, version)
+		if errors.Is(err, entries.ErrConflict) {
+			renderConflict(w, r, pool, id, title, body)
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, "database error", http.StatusInternalServerError)
 			return
 		}
@@ -126,6 +184,36 @@ func handleUpdate(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// renderConflict answers a save refused because the note changed in the
+// meantime, with what was submitted next to what is saved now.
+func renderConflict(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, id, title, body string) {
+	entry, note, err := GetByID(r.Context(), pool, id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/layout.html", "templates/conflict.html")
+	if err != nil {
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Title":  "Conflict – " + entry.Title,
+		"Entry":  entry,
+		"Action": "/notes/" + id,
+		"Fields": []entries.ConflictField{
+			{Name: "title", Label: "Title", Mine: title, Current: entry.Title},
+			{Name: "body", Label: "Body", Mine: body, Current: note.Body, Multiline: true},
+		},
+	}
+	w.WriteHeader(http.StatusConflict)
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Println("template render error:", err)
+	}
+}
+
 func handleDelete(pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
@@ -138,3 +226,145 @@ func handleDelete(pool *pgxpool.Pool) http.HandlerFunc {
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 	}
 }
+
+func handleHistory(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		entry, _, err := GetByID(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		revisions, err := ListRevisions(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/notes/templates/history.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Title":     "History – " + entry.Title,
+			"Entry":     entry,
+			"Revisions": revisions,
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+// handleRevisionDiff compares two revisions, given as from and to. Without
+// to it shows the latest change; without from it compares to with the
+// revision before it.
+func handleRevisionDiff(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		entry, _, err := GetByID(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		revisions, err := ListRevisions(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+		if len(revisions) == 0 {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		fromID, toID := r.URL.Query().Get("from"), r.URL.Query().Get("to")
+		if toID == "" {
+			toID = revisions[0].ID
+		}
+		if fromID == "" {
+			for i, rev := range revisions {
+				if rev.ID == toID && i+1 < len(revisions) {
+					fromID = revisions[i+1].ID
+				}
+			}
+		}
+
+		to, err := GetRevision(r.Context(), pool, id, toID)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		// The first revision is compared with an empty note.
+		var from Revision
+		if fromID != "" {
+			from, err = GetRevision(r.Context(), pool, id, fromID)
+			if err != nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/notes/templates/diff.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Title":   "Changes – " + entry.Title,
+			"Entry":   entry,
+			"From":    from,
+			"To":      to,
+			"Latest":  revisions[0].ID,
+			"Diff":    diff.Compact(diff.Lines(from.Body, to.Body), 3),
+			"Changed": from.Body != to.Body,
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+// handleRestore saves a revision as the note's newest, unless the note has
+// been saved since the page offering it was loaded.
+func handleRestore(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		version, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		rev, err := GetRevision(r.Context(), pool, id, r.PathValue("revision"))
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		err = Update(r.Context(), pool, id, rev.Title, rev.Body, version)
+		if errors.Is(err, entries.ErrConflict) {
+			renderConflict(w, r, pool, id, rev.Title, rev.Body)
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/notes/"+id, http.StatusSeeOther)
+	}
+}
@@ -0,0 +1,89 @@
+package notes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/database"
+	"github.com/nemouu/cairn/internal/markdown"
+)
+
+// testPool connects to the database in DATABASE_URL and brings it up to
+// date, or skips the test if none is given. Tests using it run from the
+// repository root, where the templates are.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	t.Chdir("../..")
+	ctx := context.Background()
+	pool, err := database.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	if err := database.RunMigrations(ctx, pool, "migrations"); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func TestHandleRestore(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	id, err := Create(ctx, pool, "Restore test", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Delete(context.Background(), pool, id) })
+
+	revisions, err := ListRevisions(ctx, pool, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := revisions[0].ID
+	entry, _, err := GetByID(ctx, pool, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := entry.Version
+
+	// The note is edited after the history page was opened at seen.
+	if err := Update(ctx, pool, id, "Restore test", "second", seen); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, pool, markdown.New(16))
+	restore := func(version int) *httptest.ResponseRecorder {
+		form := url.Values{"version": {strconv.Itoa(version)}}
+		r := httptest.NewRequest(http.MethodPost, "/notes/"+id+"/history/"+first+"/restore", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+		return w
+	}
+
+	if w := restore(seen); w.Code != http.StatusConflict {
+		t.Fatalf("restore at a stale version: status %d, want 409", w.Code)
+	}
+	if _, note, _ := GetByID(ctx, pool, id); note.Body != "second" {
+		t.Fatalf("stale restore overwrote the note with %q", note.Body)
+	}
+
+	if w := restore(seen + 1); w.Code != http.StatusSeeOther {
+		t.Fatalf("restore at the current version: status %d, want 303", w.Code)
+	}
+	if _, note, _ := GetByID(ctx, pool, id); note.Body != "first" {
+		t.Errorf("restored body = %q, want %q", note.Body, "first")
+	}
+}
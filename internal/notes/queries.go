@@ -2,9 +2,12 @@ package notes
 
 import (
 	"context"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nemouu/cairn/internal/entries"
+	"github.com/nemouu/cairn/internal/markdown"
 )
 
 type Note struct {
@@ -12,6 +15,18 @@ type Note struct {
 	Body    string
 }
 
+// Revision is a saved state of a note. Every save adds one, so the newest
+// revision is the note as it is now.
+type Revision struct {
+	ID        string
+	CreatedAt time.Time
+	Title     string
+	Body      string
+	// SizeDelta is how many characters the save added to the body, or
+	// removed if negative.
+	SizeDelta int
+}
+
 func Create(ctx context.Context, pool *pgxpool.Pool, title, body string) (string, error) {
 	tx, err := pool.Begin(ctx)
 	if err != nil {
@@ -36,6 +51,17 @@ func Create(ctx context.Context, pool *pgxpool.Pool, title, body string) (string
 		return "", err
 	}
 
+	if err := entries.SyncLinks(ctx, tx, id, markdown.WikiLinks(body)); err != nil {
+		return "", err
+	}
+	if err := entries.LinkTitle(ctx, tx, id, title); err != nil {
+		return "", err
+	}
+
+	if err := addRevision(ctx, tx, id, title, body, len([]rune(body))); err != nil {
+		return "", err
+	}
+
 	return id, tx.Commit(ctx)
 }
 
@@ -44,32 +70,43 @@ func GetByID(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry,
 	var n Note
 
 	err := pool.QueryRow(ctx,
-		`SELECT e.id, e.entry_type, e.title, e.created_at, e.updated_at, n.body
+		`SELECT e.id, e.entry_type, e.title, e.created_at, e.updated_at, e.version, n.body
          FROM entries e
          JOIN notes n ON n.entry_id = e.id
          WHERE e.id = $1`,
 		id,
-	).Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt, &n.Body)
+	).Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt, &e.Version, &n.Body)
 
 	n.EntryID = e.ID
 	return e, n, err
 }
 
-func Update(ctx context.Context, pool *pgxpool.Pool, id, title, body string) error {
+// Update saves a note loaded at version. It returns entries.ErrConflict if
+// the note has been saved since.
+func Update(ctx context.Context, pool *pgxpool.Pool, id, title, body string, version int) error {
 	tx, err := pool.Begin(ctx)
 	if err != nil {
 		return err
 	}
 	defer tx.Rollback(ctx)
 
-	_, err = tx.Exec(ctx,
-		`UPDATE entries SET title = $1, updated_at = now() WHERE id = $2`,
-		title, id,
-	)
+	var oldTitle, oldBody string
+	err = tx.QueryRow(ctx,
+		`SELECT e.title, n.body
+         FROM entries e
+         JOIN notes n ON n.entry_id = e.id
+         WHERE e.id = $1
+         FOR UPDATE`,
+		id,
+	).Scan(&oldTitle, &oldBody)
 	if err != nil {
 		return err
 	}
 
+	if err := entries.UpdateTitle(ctx, tx, id, title, version); err != nil {
+		return err
+	}
+
 	_, err = tx.Exec(ctx,
 		`UPDATE notes SET body = $1 WHERE entry_id = $2`,
 		body, id,
@@ -78,6 +115,17 @@ func Update(ctx context.Context, pool *pgxpool.Pool, id, title, body string) err
 		return err
 	}
 
+	if err := entries.SyncLinks(ctx, tx, id, markdown.WikiLinks(body)); err != nil {
+		return err
+	}
+
+	if title != oldTitle || body != oldBody {
+		delta := len([]rune(body)) - len([]rune(oldBody))
+		if err := addRevision(ctx, tx, id, title, body, delta); err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit(ctx)
 }
 
@@ -88,3 +136,91 @@ func Delete(ctx context.Context, pool *pgxpool.Pool, id string) error {
 	)
 	return err
 }
+
+// addRevision records the note's new state and thins out its history: all
+// revisions of the last day are kept, then the last one of each day for a
+// month, then the last one of each week. Size deltas are kept against the
+// previous surviving revision.
+func addRevision(ctx context.Context, tx pgx.Tx, id, title, body string, sizeDelta int) error {
+	_, err := tx.Exec(ctx,
+		`INSERT INTO note_revisions (entry_id, title, body, size_delta)
+         VALUES ($1, $2, $3, $4)`,
+		id, title, body, sizeDelta,
+	)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`DELETE FROM note_revisions
+         WHERE entry_id = $1 AND id NOT IN (
+             SELECT DISTINCT ON (bucket) id
+             FROM (
+                 SELECT id, created_at,
+                        CASE
+                            WHEN created_at > now() - interval '1 day' THEN id::text
+                            WHEN created_at > now() - interval '30 days' THEN to_char(created_at, 'YYYY-MM-DD')
+                            ELSE to_char(created_at, 'IYYY-IW')
+                        END AS bucket
+                 FROM note_revisions
+                 WHERE entry_id = $1
+             ) r
+             ORDER BY bucket, created_at DESC
+         )`,
+		id,
+	)
+	if err != nil {
+		return err
+	}
+
+	// Deltas of the revisions that are left must be against the revision
+	// now before them, not one that was just thinned out.
+	_, err = tx.Exec(ctx,
+		`UPDATE note_revisions r
+         SET size_delta = d.size_delta
+         FROM (
+             SELECT id, char_length(body) - COALESCE(lag(char_length(body)) OVER (ORDER BY created_at), 0) AS size_delta
+             FROM note_revisions
+             WHERE entry_id = $1
+         ) d
+         WHERE r.id = d.id AND r.size_delta <> d.size_delta`,
+		id,
+	)
+	return err
+}
+
+// ListRevisions returns a note's revisions, newest first, without bodies.
+func ListRevisions(ctx context.Context, pool *pgxpool.Pool, id string) ([]Revision, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT id, created_at, title, size_delta
+         FROM note_revisions
+         WHERE entry_id = $1
+         ORDER BY created_at DESC`,
+		id,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []Revision
+	for rows.Next() {
+		var r Revision
+		if err := rows.Scan(&r.ID, &r.CreatedAt, &r.Title, &r.SizeDelta); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, r)
+	}
+	return revisions, rows.Err()
+}
+
+func GetRevision(ctx context.Context, pool *pgxpool.Pool, id, revisionID string) (Revision, error) {
+	var r Revision
+	err := pool.QueryRow(ctx,
+		`SELECT id, created_at, title, body, size_delta
+         FROM note_revisions
+         WHERE entry_id = $1 AND id = $2`,
+		id, revisionID,
+	).Scan(&r.ID, &r.CreatedAt, &r.Title, &r.Body, &r.SizeDelta)
+	return r, err
+}
@@ -0,0 +1,283 @@
+// Package quickadd reads the shorthand typed into a single line when adding
+// a todo item, such as "Buy milk tomorrow #errands !high @home".
+package quickadd
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Item is what a line of quick-add text says about a new item.
+type Item struct {
+	// Body is the text left once the other parts are taken out.
+	Body string
+	// DueDate is a calendar date at midnight UTC; DueTime, "15:04", is only
+	// set along with it.
+	DueDate *time.Time
+	DueTime *string
+	Tags    []string // lower case, without "#", each once
+	// Priority is "high", "medium", "low", or "" for none.
+	Priority string
+	Context  string // without "@"
+}
+
+// priorities are the words after "!" that set a priority, and what they
+// set it to.
+var priorities = map[string]string{
+	"high":   "high",
+	"h":      "high",
+	"medium": "medium",
+	"med":    "medium",
+	"m":      "medium",
+	"low":    "low",
+	"l":      "low",
+}
+
+var (
+	wordPattern  = regexp.MustCompile(`^[\pL][\pL\pN_/-]*$`)
+	clockPattern = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+)
+
+// Parse reads text relative to now, the moment it was typed. The first
+// date, and the first time of day, it finds are used; later ones are left
+// in the body. So are words that only look like the shorthand, such as
+// "#" on its own or an "!" priority it doesn't know, and dates that are
+// part of a title, as in "Watch The Day After Tomorrow".
+func Parse(text string, now time.Time) Item {
+	return parse(text, now, true)
+}
+
+// ParseUndated reads only the tags, priority and context in text, leaving
+// any dates and times in the body. It suits items that are not due on a
+// day of their own, such as those of a template.
+func ParseUndated(text string) Item {
+	return parse(text, time.Time{}, false)
+}
+
+func parse(text string, now time.Time, dated bool) Item {
+	var item Item
+	words := strings.Fields(text)
+	inTitle := titleWords(words)
+	var body []string
+
+	for i := 0; i < len(words); i++ {
+		word := words[i]
+		lower := strings.ToLower(word)
+
+		switch {
+		case strings.HasPrefix(word, "#") && wordPattern.MatchString(word[1:]):
+			tag := strings.ToLower(word[1:])
+			if !slices.Contains(item.Tags, tag) {
+				item.Tags = append(item.Tags, tag)
+			}
+			continue
+		case strings.HasPrefix(word, "!") && priorities[lower[1:]] != "":
+			item.Priority = priorities[lower[1:]]
+			continue
+		case strings.HasPrefix(word, "@") && wordPattern.MatchString(word[1:]):
+			item.Context = word[1:]
+			continue
+		}
+
+		if !dated {
+			body = append(body, word)
+			continue
+		}
+		if item.DueDate == nil && !inTitle[i] {
+			if date, n := parseDate(words[i:], now); n > 0 {
+				item.DueDate = &date
+				i += n - 1
+				continue
+			}
+		}
+		if item.DueTime == nil {
+			if clock, n := parseTime(words[i:]); n > 0 {
+				item.DueTime = &clock
+				i += n - 1
+				continue
+			}
+		}
+
+		body = append(body, word)
+	}
+
+	// A time alone is for today, or tomorrow if it has passed.
+	if item.DueTime != nil && item.DueDate == nil {
+		day := midnight(now, 0)
+		if *item.DueTime <= now.Format("15:04") {
+			day = midnight(now, 1)
+		}
+		item.DueDate = &day
+	}
+
+	item.Body = strings.Join(body, " ")
+	return item
+}
+
+// titleWords marks the words that are part of a title: runs of three or
+// more capitalized words, not counting the first word of the text, which is
+// capitalized anyway.
+func titleWords(words []string) []bool {
+	inTitle := make([]bool, len(words))
+	start := 1
+	for i := 1; i <= len(words); i++ {
+		if i < len(words) && capitalized(words[i]) {
+			continue
+		}
+		if i-start >= 3 {
+			for j := start; j < i; j++ {
+				inTitle[j] = true
+			}
+		}
+		start = i + 1
+	}
+	return inTitle
+}
+
+func capitalized(word string) bool {
+	r, _ := utf8.DecodeRuneInString(word)
+	return unicode.IsUpper(r)
+}
+
+// parseDate reads a date phrase at the start of words, returning the date
+// and how many words it took, or 0 if there is none.
+func parseDate(words []string, now time.Time) (time.Time, int) {
+	word := func(i int) string {
+		if i < len(words) {
+			return strings.ToLower(words[i])
+		}
+		return ""
+	}
+
+	switch word(0) {
+	case "today":
+		return midnight(now, 0), 1
+	case "tomorrow", "tmrw":
+		return midnight(now, 1), 1
+	case "on", "by":
+		if day, ok := weekday(word(1), true); ok {
+			return nextWeekday(now, day), 2
+		}
+		if word(1) != "on" && word(1) != "by" && len(words) > 1 {
+			if date, n := parseDate(words[1:], now); n > 0 {
+				return date, n + 1
+			}
+		}
+	case "next":
+		if day, ok := weekday(word(1), true); ok {
+			return nextWeekday(now, day), 2
+		}
+		switch word(1) {
+		case "week":
+			return midnight(now, 7), 2
+		case "month":
+			return addMonths(now, 1), 2
+		}
+	case "in":
+		n, err := strconv.Atoi(word(1))
+		if word(1) == "a" {
+			n, err = 1, nil
+		}
+		if err != nil || n < 0 || n > 3650 {
+			break
+		}
+		switch strings.TrimSuffix(word(2), "s") {
+		case "day":
+			return midnight(now, n), 3
+		case "week":
+			return midnight(now, 7*n), 3
+		case "month":
+			return addMonths(now, n), 3
+		}
+	}
+
+	if day, ok := weekday(word(0), false); ok {
+		return nextWeekday(now, day), 1
+	}
+	if date, err := time.Parse(time.DateOnly, word(0)); err == nil {
+		return date, 1
+	}
+	return time.Time{}, 0
+}
+
+// parseTime reads "at 9", "at 14:30", "9am" or "2:30pm" at the start of
+// words. Without "at" a time needs am or pm, so that counts and references
+// such as "John 3:16" are not taken for times.
+func parseTime(words []string) (string, int) {
+	lower := strings.ToLower(words[0])
+	if lower == "at" && len(words) > 1 {
+		if clock, ok := clockTime(strings.ToLower(words[1]), true); ok {
+			return clock, 2
+		}
+		return "", 0
+	}
+	if clock, ok := clockTime(lower, false); ok {
+		return clock, 1
+	}
+	return "", 0
+}
+
+func clockTime(s string, bare bool) (string, bool) {
+	m := clockPattern.FindStringSubmatch(s)
+	if m == nil || (!bare && m[3] == "") {
+		return "", false
+	}
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+
+	switch m[3] {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return "", false
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return "", false
+	}
+	return time.Date(0, 1, 1, hour, minute, 0, 0, time.UTC).Format("15:04"), true
+}
+
+// weekday reads a weekday's name, or with short its first three letters
+// too. Those are only taken after a word like "next", as "sat" and "sun"
+// are words of their own.
+func weekday(s string, short bool) (time.Weekday, bool) {
+	for d := time.Sunday; d <= time.Saturday; d++ {
+		name := strings.ToLower(d.String())
+		if s == name || (short && s == name[:3]) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// nextWeekday is the first day after now that falls on day.
+func nextWeekday(now time.Time, day time.Weekday) time.Time {
+	ahead := (int(day)-int(now.Weekday())+6)%7 + 1
+	return midnight(now, ahead)
+}
+
+// midnight is the date days after now's date, at midnight UTC.
+func midnight(now time.Time, days int) time.Time {
+	y, m, d := now.Date()
+	return time.Date(y, m, d+days, 0, 0, 0, 0, time.UTC)
+}
+
+// addMonths is the same day n months after now's date, or the last day of
+// that month if it is shorter.
+func addMonths(now time.Time, n int) time.Time {
+	y, m, d := now.Date()
+	last := time.Date(y, m+time.Month(n)+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return time.Date(y, m+time.Month(n), min(d, last), 0, 0, 0, 0, time.UTC)
+}
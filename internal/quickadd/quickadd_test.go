@@ -0,0 +1,117 @@
+package quickadd
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// now is Wednesday 14 October 2026, 10:00.
+var now = time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		text     string
+		body     string
+		due      string // "2006-01-02", or "" for none
+		clock    string // "15:04", or "" for none
+		tags     []string
+		priority string
+		context  string
+	}{
+		{text: "Buy milk tomorrow #errands !high @home", body: "Buy milk", due: "2026-10-15",
+			tags: []string{"errands"}, priority: "high", context: "home"},
+		{text: "Buy milk", body: "Buy milk"},
+		{text: "Call mom today", body: "Call mom", due: "2026-10-14"},
+		{text: "Call mom tmrw", body: "Call mom", due: "2026-10-15"},
+		{text: "Pay rent next fri", body: "Pay rent", due: "2026-10-16"},
+		{text: "Pay rent next Friday", body: "Pay rent", due: "2026-10-16"},
+		{text: "Pay rent friday", body: "Pay rent", due: "2026-10-16"},
+		{text: "Team lunch on wed", body: "Team lunch", due: "2026-10-21"},
+		{text: "Send report by tomorrow", body: "Send report", due: "2026-10-15"},
+		{text: "Renew passport in 3 days", body: "Renew passport", due: "2026-10-17"},
+		{text: "Renew passport in a week", body: "Renew passport", due: "2026-10-21"},
+		{text: "Renew passport in 2 weeks", body: "Renew passport", due: "2026-10-28"},
+		{text: "Renew passport next week", body: "Renew passport", due: "2026-10-21"},
+		{text: "Dentist in 1 month", body: "Dentist", due: "2026-11-14"},
+		{text: "Dentist 2026-11-02", body: "Dentist", due: "2026-11-02"},
+		{text: "Call Anna tomorrow at 9", body: "Call Anna", due: "2026-10-15", clock: "09:00"},
+		{text: "Standup at 14:30", body: "Standup", due: "2026-10-14", clock: "14:30"},
+		{text: "Standup 5pm", body: "Standup", due: "2026-10-14", clock: "17:00"},
+		{text: "Standup 9:15am", body: "Standup", due: "2026-10-15", clock: "09:15"},
+		{text: "Groceries #Errands #home #errands", body: "Groceries", tags: []string{"errands", "home"}},
+		{text: "Taxes !m @desk", body: "Taxes", priority: "medium", context: "desk"},
+		{text: "Taxes !low", body: "Taxes", priority: "low"},
+		{text: "Move tomorrow to friday", body: "Move to friday", due: "2026-10-15"},
+
+		// Things that only look like the shorthand stay in the body.
+		{text: "Read John 3:16", body: "Read John 3:16"},
+		{text: "Read John 3:16 tomorrow", body: "Read John 3:16", due: "2026-10-15"},
+		{text: "Watch The Day After Tomorrow", body: "Watch The Day After Tomorrow"},
+		{text: "Watch Friday Night Lights on sunday", body: "Watch Friday Night Lights", due: "2026-10-18"},
+		{text: "Sat on the chair", body: "Sat on the chair"},
+		{text: "Buy 3 apples", body: "Buy 3 apples"},
+		{text: "Put it in 2 bags", body: "Put it in 2 bags"},
+		{text: "Meet at noon", body: "Meet at noon"},
+		{text: "Fix it !urgent", body: "Fix it !urgent"},
+		{text: "Use the # key", body: "Use the # key"},
+		{text: "Email me@example.com", body: "Email me@example.com"},
+		{text: "Ship v2 13pm", body: "Ship v2 13pm"},
+		{text: "", body: ""},
+	}
+	for _, tt := range tests {
+		got := Parse(tt.text, now)
+		if got.Body != tt.body {
+			t.Errorf("Parse(%q).Body = %q, want %q", tt.text, got.Body, tt.body)
+		}
+		if due := format(got.DueDate, time.DateOnly); due != tt.due {
+			t.Errorf("Parse(%q).DueDate = %q, want %q", tt.text, due, tt.due)
+		}
+		if clock := deref(got.DueTime); clock != tt.clock {
+			t.Errorf("Parse(%q).DueTime = %q, want %q", tt.text, clock, tt.clock)
+		}
+		if !slices.Equal(got.Tags, tt.tags) {
+			t.Errorf("Parse(%q).Tags = %q, want %q", tt.text, got.Tags, tt.tags)
+		}
+		if got.Priority != tt.priority {
+			t.Errorf("Parse(%q).Priority = %q, want %q", tt.text, got.Priority, tt.priority)
+		}
+		if got.Context != tt.context {
+			t.Errorf("Parse(%q).Context = %q, want %q", tt.text, got.Context, tt.context)
+		}
+	}
+}
+
+func TestAddMonthsClamps(t *testing.T) {
+	jan31 := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	if got := addMonths(jan31, 1).Format(time.DateOnly); got != "2026-02-28" {
+		t.Errorf("addMonths(31 Jan, 1) = %s, want 2026-02-28", got)
+	}
+}
+
+func format(t *time.Time, layout string) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(layout)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func TestParseUndated(t *testing.T) {
+	got := ParseUndated("Call host tomorrow at 9 #trip !high @phone")
+	if got.Body != "Call host tomorrow at 9" {
+		t.Errorf("Body = %q, want %q", got.Body, "Call host tomorrow at 9")
+	}
+	if got.DueDate != nil || got.DueTime != nil {
+		t.Errorf("due = %v %v, want none", got.DueDate, got.DueTime)
+	}
+	if !slices.Equal(got.Tags, []string{"trip"}) || got.Priority != "high" || got.Context != "phone" {
+		t.Errorf("got %q, %q, %q; want [trip], high, phone", got.Tags, got.Priority, got.Context)
+	}
+}
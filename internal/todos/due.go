@@ -0,0 +1,113 @@
+package todos
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Due is when the item falls due in local time: its due time, or the end of
+// its due date. It is the zero time for items without a due date.
+func (t TodoItem) Due() time.Time {
+	if t.DueDate == nil {
+		return time.Time{}
+	}
+	y, m, d := t.DueDate.Date()
+	if t.DueTime != nil {
+		if clock, err := time.Parse("15:04", *t.DueTime); err == nil {
+			return time.Date(y, m, d, clock.Hour(), clock.Minute(), 0, 0, time.Local)
+		}
+	}
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.Local)
+}
+
+// Overdue reports whether an open item is past its due time.
+func (t TodoItem) Overdue() bool {
+	return !t.IsDone && t.DueDate != nil && time.Now().After(t.Due())
+}
+
+// DueToday reports whether an open item falls due later today.
+func (t TodoItem) DueToday() bool {
+	if t.IsDone || t.DueDate == nil || t.Overdue() {
+		return false
+	}
+	y, m, d := time.Now().Date()
+	dy, dm, dd := t.DueDate.Date()
+	return y == dy && m == dm && d == dd
+}
+
+// DueLabel is the due date, and time if there is one, for display.
+func (t TodoItem) DueLabel() string {
+	if t.DueDate == nil {
+		return ""
+	}
+	label := t.DueDate.Format("Mon 2 Jan 2006")
+	if t.DueTime != nil {
+		label += ", " + *t.DueTime
+	}
+	return label
+}
+
+// DueItem is an item listed across todo lists, with the list it is on.
+type DueItem struct {
+	ListTitle string
+	TodoItem
+}
+
+// Path is where the item is shown on its list.
+func (d DueItem) Path() string {
+	return "/todos/" + d.EntryID + "#item-" + d.ID
+}
+
+// ListDue returns the open items that have a due date, across all lists,
+// soonest first.
+func ListDue(ctx context.Context, pool *pgxpool.Pool) ([]DueItem, error) {
+	rows, err := pool.Query(ctx,
+		`SELECT e.title, `+itemColumns+`
+         FROM todo_items i
+         JOIN entries e ON e.id = i.entry_id
+         WHERE i.due_date IS NOT NULL AND NOT i.is_done
+         ORDER BY i.due_date, i.due_time NULLS LAST, lower(e.title), i.position`)
+	if err != nil {
+		return nil, err
+	}
+	return scanDueItems(rows)
+}
+
+func scanDueItems(rows pgx.Rows) ([]DueItem, error) {
+	defer rows.Close()
+
+	var list []DueItem
+	for rows.Next() {
+		var d DueItem
+		if err := rows.Scan(append([]any{&d.ListTitle}, itemFields(&d.TodoItem)...)...); err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+// DueGroups splits due items for the due page.
+type DueGroups struct {
+	Overdue  []DueItem
+	Today    []DueItem
+	Upcoming []DueItem
+}
+
+func GroupDue(list []DueItem) DueGroups {
+	var g DueGroups
+	for _, d := range list {
+		switch {
+		case d.Overdue():
+			g.Overdue = append(g.Overdue, d)
+		case d.DueToday():
+			g.Today = append(g.Today, d)
+		default:
+			g.Upcoming = append(g.Upcoming, d)
+		}
+	}
+	return g
+}
@@ -1,25 +1,46 @@
 package todos
 
 import (
+	"errors"
 	"html/template"
 	"log"
 	"net/http"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/attachments"
+	"github.com/nemouu/cairn/internal/entries"
+	"github.com/nemouu/cairn/internal/quickadd"
 )
 
 func RegisterRoutes(mux *http.ServeMux, pool *pgxpool.Pool) {
 	mux.HandleFunc("GET /todos/new", handleForm(pool, false))
+	mux.HandleFunc("GET /todos/due", handleDue(pool))
 	mux.HandleFunc("POST /todos", handleCreate(pool))
 	mux.HandleFunc("GET /todos/{id}", handleView(pool))
 	mux.HandleFunc("GET /todos/{id}/edit", handleForm(pool, true))
 	mux.HandleFunc("POST /todos/{id}", handleUpdate(pool))
 	mux.HandleFunc("POST /todos/{id}/delete", handleDelete(pool))
-	mux.HandleFunc("POST /todos/{id}/items", handleAddItem(pool))
+	mux.HandleFunc("POST /todos/{id}/items", handleAddItem(pool, "/todos/"))
 	mux.HandleFunc("POST /todos/{id}/items/{itemID}/update", handleUpdateItem(pool))
+	mux.HandleFunc("POST /todos/{id}/items/{itemID}/move", handleMoveItem(pool, "/todos/"))
+	mux.HandleFunc("POST /todos/{id}/items/{itemID}/due", handleSetItemDue(pool))
 	mux.HandleFunc("POST /todos/{id}/items/{itemID}/toggle", handleToggleItem(pool))
-	mux.HandleFunc("POST /todos/{id}/items/{itemID}/delete", handleDeleteItem(pool))
+	mux.HandleFunc("POST /todos/{id}/items/{itemID}/delete", handleDeleteItem(pool, "/todos/"))
+
+	mux.HandleFunc("GET /todo_templates/new", handleTemplateForm(pool, false))
+	mux.HandleFunc("POST /todo_templates", handleCreateTemplate(pool))
+	mux.HandleFunc("GET /todo_templates/{id}", handleViewTemplate(pool))
+	mux.HandleFunc("GET /todo_templates/{id}/edit", handleTemplateForm(pool, true))
+	mux.HandleFunc("POST /todo_templates/{id}", handleUpdateTemplate(pool))
+	mux.HandleFunc("POST /todo_templates/{id}/delete", handleDelete(pool))
+	mux.HandleFunc("POST /todo_templates/{id}/instantiate", handleInstantiate(pool))
+	mux.HandleFunc("POST /todo_templates/{id}/items", handleAddItem(pool, "/todo_templates/"))
+	mux.HandleFunc("POST /todo_templates/{id}/items/{itemID}/move", handleMoveItem(pool, "/todo_templates/"))
+	mux.HandleFunc("POST /todo_templates/{id}/items/{itemID}/delete", handleDeleteItem(pool, "/todo_templates/"))
 }
 
 // handleForm
@@ -88,16 +109,30 @@ func handleView(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		tmpl, err := template.ParseFiles("templates/layout.html", "internal/todos/templates/view.html")
+		backlinks, err := entries.Backlinks(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		files, err := attachments.List(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "templates/backlinks.html", "templates/attachments.html", "internal/todos/templates/item_meta.html", "internal/todos/templates/view.html")
 		if err != nil {
 			http.Error(w, "template error", http.StatusInternalServerError)
 			return
 		}
 
 		data := map[string]any{
-			"Title": entry.Title,
-			"Entry": entry,
-			"Todo":  todoItems,
+			"Title":       entry.Title,
+			"Entry":       entry,
+			"Todo":        Tree(todoItems),
+			"Backlinks":   backlinks,
+			"Attachments": files,
 		}
 		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
 			log.Println("template render error:", err)
@@ -116,13 +151,27 @@ func handleUpdate(pool *pgxpool.Pool) http.HandlerFunc {
 		}
 
 		title := strings.TrimSpace(r.FormValue("title"))
+		version, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
 
 		if title == "" {
 			http.Error(w, "title is required", http.StatusBadRequest)
 			return
 		}
 
-		if err := Update(r.Context(), pool, id, title); err != nil {
+		err = Update(r.Context(), pool, id, title, version)
+		if errors.Is(err, entries.ErrConflict) {
+			renderConflict(w, r, pool, id, title)
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, "database error", http.StatusInternalServerError)
 			return
 		}
@@ -131,6 +180,35 @@ func handleUpdate(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
+// renderConflict shows a title save that was refused because the todo list
+// or template changed in the meantime.
+func renderConflict(w http.ResponseWriter, r *http.Request, pool *pgxpool.Pool, id, title string) {
+	entry, _, err := GetByID(r.Context(), pool, id)
+	if err != nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	}
+
+	tmpl, err := template.ParseFiles("templates/layout.html", "templates/conflict.html")
+	if err != nil {
+		http.Error(w, "template error", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]any{
+		"Title":  "Conflict – " + entry.Title,
+		"Entry":  entry,
+		"Action": entry.Path(),
+		"Fields": []entries.ConflictField{
+			{Name: "title", Label: "Title", Mine: title, Current: entry.Title},
+		},
+	}
+	w.WriteHeader(http.StatusConflict)
+	if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+		log.Println("template render error:", err)
+	}
+}
+
 // handleDelete
 func handleDelete(pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -145,8 +223,9 @@ func handleDelete(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-// handleAddItem
-func handleAddItem(pool *pgxpool.Pool) http.HandlerFunc {
+// handleAddItem adds an item to the list or template under base, below the
+// item in "parent" if there is one.
+func handleAddItem(pool *pgxpool.Pool, base string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 
@@ -155,19 +234,143 @@ func handleAddItem(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		body := r.FormValue("body")
+		item, err := itemFromForm(r, id, base != "/todo_templates/", time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 
-		if body == "" {
-			http.Error(w, "body is required", http.StatusBadRequest)
+		err = AddItem(r.Context(), pool, item)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		if item.ParentID != nil {
+			http.Redirect(w, r, base+id+"#item-"+*item.ParentID, http.StatusSeeOther)
+			return
+		}
+		http.Redirect(w, r, base+id, http.StatusSeeOther)
+	}
+}
+
+// itemFromForm reads a new item of entryID from a parsed form. The body is
+// read as quick-add text; a due date from the form's own fields wins over
+// one in the text. Without dated, as for template items, whose lists are
+// made later, dates and times are left in the body and the due fields are
+// ignored.
+func itemFromForm(r *http.Request, entryID string, dated bool, now time.Time) (TodoItem, error) {
+	item := TodoItem{EntryID: entryID}
+	if parent := r.FormValue("parent"); parent != "" {
+		item.ParentID = &parent
+	}
+
+	var quick quickadd.Item
+	if !dated {
+		quick = quickadd.ParseUndated(r.FormValue("body"))
+	} else {
+		quick = quickadd.Parse(r.FormValue("body"), now)
+		dueDate, dueTime, err := parseDue(r)
+		if err != nil {
+			return item, err
+		}
+		if dueDate == nil {
+			dueDate, dueTime = quick.DueDate, quick.DueTime
+		}
+		item.DueDate, item.DueTime = dueDate, dueTime
+	}
+	if quick.Body == "" {
+		return item, errors.New("body is required")
+	}
+
+	item.Body = quick.Body
+	item.Tags = quick.Tags
+	item.Priority = quick.Priority
+	item.Context = quick.Context
+	return item, nil
+}
+
+// parseDue reads the optional "due_date" and "due_time" fields of a form, as
+// sent by date and time inputs. A time without a date is ignored.
+func parseDue(r *http.Request) (*time.Time, *string, error) {
+	date := r.FormValue("due_date")
+	if date == "" {
+		return nil, nil, nil
+	}
+	dueDate, err := time.Parse(time.DateOnly, date)
+	if err != nil {
+		return nil, nil, errors.New("invalid due date")
+	}
+
+	clock := r.FormValue("due_time")
+	if clock == "" {
+		return &dueDate, nil, nil
+	}
+	t, err := time.Parse("15:04", clock)
+	if err != nil {
+		return nil, nil, errors.New("invalid due time")
+	}
+	clock = t.Format("15:04")
+	return &dueDate, &clock, nil
+}
+
+// handleSetItemDue
+func handleSetItemDue(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		itemID := r.PathValue("itemID")
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		dueDate, dueTime, err := parseDue(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if err := AddItem(r.Context(), pool, id, body); err != nil {
+		err = SetItemDue(r.Context(), pool, itemID, dueDate, dueTime)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, "database error", http.StatusInternalServerError)
 			return
 		}
 
-		http.Redirect(w, r, "/todos/"+id, http.StatusSeeOther)
+		http.Redirect(w, r, "/todos/"+id+"#item-"+itemID, http.StatusSeeOther)
+	}
+}
+
+// handleDue lists open items with due dates across all lists.
+func handleDue(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		list, err := ListDue(r.Context(), pool)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/todos/templates/due.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Title": "Due",
+			"Due":   GroupDue(list),
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
 	}
 }
 
@@ -199,23 +402,81 @@ func handleUpdateItem(pool *pgxpool.Pool) http.HandlerFunc {
 	}
 }
 
-// handleToggleItem
+// handleMoveItem moves an item to the 0-based "index", as sent by dragging,
+// or by "by" places, as sent by the up and down buttons. Script requests get
+// an empty answer rather than the page.
+func handleMoveItem(pool *pgxpool.Pool, base string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+		itemID := r.PathValue("itemID")
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		var err error
+		if v := r.FormValue("index"); v != "" {
+			index, convErr := strconv.Atoi(v)
+			if convErr != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			err = MoveItem(r.Context(), pool, id, itemID, index)
+		} else {
+			by, convErr := strconv.Atoi(r.FormValue("by"))
+			if convErr != nil {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			err = ShiftItem(r.Context(), pool, id, itemID, by)
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			log.Println("move item error:", err)
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		if r.Header.Get("X-Requested-With") == "fetch" {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.Redirect(w, r, base+id+"#item-"+itemID, http.StatusSeeOther)
+	}
+}
+
+// handleToggleItem checks or unchecks an item, and all its sub-items too if
+// "cascade" is set.
 func handleToggleItem(pool *pgxpool.Pool) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		itemID := r.PathValue("itemID")
 
-		if err := ToggleItem(r.Context(), pool, itemID); err != nil {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		err := ToggleItem(r.Context(), pool, itemID, r.FormValue("cascade") != "")
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
 			http.Error(w, "database error", http.StatusInternalServerError)
 			return
 		}
 
-		http.Redirect(w, r, "/todos/"+id, http.StatusSeeOther)
+		http.Redirect(w, r, "/todos/"+id+"#item-"+itemID, http.StatusSeeOther)
 	}
 }
 
 // handleDeleteItem
-func handleDeleteItem(pool *pgxpool.Pool) http.HandlerFunc {
+func handleDeleteItem(pool *pgxpool.Pool, base string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		id := r.PathValue("id")
 		itemID := r.PathValue("itemID")
@@ -225,6 +486,220 @@ func handleDeleteItem(pool *pgxpool.Pool) http.HandlerFunc {
 			return
 		}
 
-		http.Redirect(w, r, "/todos/"+id, http.StatusSeeOther)
+		http.Redirect(w, r, base+id, http.StatusSeeOther)
+	}
+}
+
+// parseRecurrence reads the recurrence fields of the template form.
+func parseRecurrence(r *http.Request) (Recurrence, error) {
+	switch kind := r.FormValue("repeat"); kind {
+	case "weekly":
+		return ParseRecurrence(kind + ":" + r.FormValue("weekday"))
+	case "monthly":
+		return ParseRecurrence(kind + ":" + r.FormValue("monthday"))
+	default:
+		return ParseRecurrence(kind)
+	}
+}
+
+// weekdays are the choices for weekly recurrence, in form order.
+var weekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// handleTemplateForm
+func handleTemplateForm(pool *pgxpool.Pool, isEdit bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data := map[string]any{
+			"Title":    "New Todo Template",
+			"IsEdit":   isEdit,
+			"Weekdays": weekdays,
+			"Template": Template{Recurrence: Recurrence{Weekday: time.Monday, Day: 1}},
+		}
+
+		if isEdit {
+			id := r.PathValue("id")
+			entry, tmpl, _, err := GetTemplate(r.Context(), pool, id)
+			if err != nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			data["Title"] = "Edit – " + entry.Title
+			data["Entry"] = entry
+			data["Template"] = tmpl
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "internal/todos/templates/template_form.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+// handleCreateTemplate
+func handleCreateTemplate(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		title := strings.TrimSpace(r.FormValue("title"))
+		rec, err := parseRecurrence(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if title == "" {
+			http.Error(w, "title is required", http.StatusBadRequest)
+			return
+		}
+
+		id, err := CreateTemplate(r.Context(), pool, title, rec)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/todo_templates/"+id, http.StatusSeeOther)
+	}
+}
+
+// handleViewTemplate
+func handleViewTemplate(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		entry, tmplData, todoItems, err := GetTemplate(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+
+		backlinks, err := entries.Backlinks(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		files, err := attachments.List(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		tmpl, err := template.ParseFiles("templates/layout.html", "templates/backlinks.html", "templates/attachments.html", "internal/todos/templates/item_meta.html", "internal/todos/templates/template_view.html")
+		if err != nil {
+			http.Error(w, "template error", http.StatusInternalServerError)
+			return
+		}
+
+		data := map[string]any{
+			"Title":         entry.Title,
+			"Entry":         entry,
+			"Template":      tmplData,
+			"Todo":          todoItems,
+			"InstanceTitle": InstanceTitle(entry.Title, time.Now()),
+			"Backlinks":     backlinks,
+			"Attachments":   files,
+		}
+		if err := tmpl.ExecuteTemplate(w, "layout.html", data); err != nil {
+			log.Println("template render error:", err)
+		}
+	}
+}
+
+// handleUpdateTemplate
+func handleUpdateTemplate(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		title := strings.TrimSpace(r.FormValue("title"))
+		version, err := strconv.Atoi(r.FormValue("version"))
+		if err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		if title == "" {
+			http.Error(w, "title is required", http.StatusBadRequest)
+			return
+		}
+
+		// The conflict page only sends the title back; the recurrence then
+		// stays as it is.
+		_, current, _, err := GetTemplate(r.Context(), pool, id)
+		if err != nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		rec := current.Recurrence
+		if r.Form.Has("repeat") {
+			if rec, err = parseRecurrence(r); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		err = UpdateTemplate(r.Context(), pool, id, title, rec, version)
+		if errors.Is(err, entries.ErrConflict) {
+			renderConflict(w, r, pool, id, title)
+			return
+		}
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/todo_templates/"+id, http.StatusSeeOther)
+	}
+}
+
+// handleInstantiate makes a new list from a template, titled as the form
+// says or after the template and today's date.
+func handleInstantiate(pool *pgxpool.Pool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := r.PathValue("id")
+
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+
+		title := strings.TrimSpace(r.FormValue("title"))
+		if title == "" {
+			entry, _, err := GetByID(r.Context(), pool, id)
+			if err != nil {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+			title = InstanceTitle(entry.Title, time.Now())
+		}
+
+		listID, err := Instantiate(r.Context(), pool, id, title)
+		if errors.Is(err, pgx.ErrNoRows) {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "database error", http.StatusInternalServerError)
+			return
+		}
+
+		http.Redirect(w, r, "/todos/"+listID, http.StatusSeeOther)
 	}
 }
@@ -0,0 +1,104 @@
+package todos
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func postForm(target string, form url.Values) *http.Request {
+	r := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestItemFromForm(t *testing.T) {
+	// Wednesday 14 October 2026, 10:00.
+	now := time.Date(2026, 10, 14, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name  string
+		form  url.Values
+		dated bool
+		body  string
+		due   string // "2006-01-02 15:04", the date alone, or ""
+		tags  []string
+	}{
+		{"list", url.Values{"body": {"Pack bags tomorrow #travel"}}, true,
+			"Pack bags", "2026-10-15", []string{"travel"}},
+		{"list with time", url.Values{"body": {"Call host at 9"}}, true,
+			"Call host", "2026-10-15 09:00", nil},
+		{"form date wins", url.Values{"body": {"Pack bags tomorrow"}, "due_date": {"2026-10-20"}}, true,
+			"Pack bags", "2026-10-20", nil},
+		{"template keeps date words", url.Values{"body": {"Pack bags tomorrow #travel"}}, false,
+			"Pack bags tomorrow", "", []string{"travel"}},
+		{"template keeps time words", url.Values{"body": {"Call host at 9 !high"}}, false,
+			"Call host at 9", "", nil},
+		{"template ignores due fields", url.Values{"body": {"Call host"}, "due_date": {"2026-10-20"}}, false,
+			"Call host", "", nil},
+	}
+	for _, tt := range tests {
+		r := postForm("/", tt.form)
+		item, err := itemFromForm(r, "list", tt.dated, now)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if item.Body != tt.body {
+			t.Errorf("%s: body = %q, want %q", tt.name, item.Body, tt.body)
+		}
+		var due string
+		if item.DueDate != nil {
+			due = item.DueDate.Format(time.DateOnly)
+			if item.DueTime != nil {
+				due += " " + *item.DueTime
+			}
+		}
+		if due != tt.due {
+			t.Errorf("%s: due = %q, want %q", tt.name, due, tt.due)
+		}
+		if !slices.Equal(item.Tags, tt.tags) {
+			t.Errorf("%s: tags = %q, want %q", tt.name, item.Tags, tt.tags)
+		}
+	}
+
+	if _, err := itemFromForm(postForm("/", url.Values{"body": {"tomorrow"}}), "list", true, now); err == nil {
+		t.Error("item of only a date was accepted")
+	}
+}
+
+func TestHandleAddItemToTemplate(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	id, err := CreateTemplate(ctx, pool, "Trip", Recurrence{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Delete(context.Background(), pool, id) })
+
+	mux := http.NewServeMux()
+	RegisterRoutes(mux, pool)
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, postForm("/todo_templates/"+id+"/items", url.Values{"body": {"Pack bags tomorrow #travel"}}))
+	if w.Code != http.StatusSeeOther {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+
+	_, _, items, err := GetTemplate(ctx, pool, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("template has %d items, want 1", len(items))
+	}
+	if item := items[0]; item.Body != "Pack bags tomorrow" || item.DueDate != nil || !slices.Equal(item.Tags, []string{"travel"}) {
+		t.Errorf("item = %q due %v tags %q, want %q with no due date, tagged travel",
+			item.Body, item.DueDate, item.Tags, "Pack bags tomorrow")
+	}
+}
@@ -2,31 +2,66 @@ package todos
 
 import (
 	"context"
+	"errors"
+	"slices"
 	"time"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/nemouu/cairn/internal/entries"
 )
 
 type TodoItem struct {
-	ID        string
-	EntryID   string
+	ID      string
+	EntryID string
+	// ParentID is the item this one is a sub-item of. Positions count
+	// from 1 among the items under the same parent.
+	ParentID  *string
 	Body      string
 	IsDone    bool
 	Position  int
 	CreatedAt time.Time
+	// DueDate is a calendar date; DueTime, "15:04", is only set if the
+	// item is due at a particular time of that day.
+	DueDate *time.Time
+	DueTime *string
+	Tags    []string
+	// Priority is "high", "medium", "low", or "" for none.
+	Priority string
+	Context  string
+
+	// Children is only filled in by Tree.
+	Children []TodoItem
 }
 
 func Create(ctx context.Context, pool *pgxpool.Pool, title string) (string, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
 	var id string
-	err := pool.QueryRow(ctx,
+	err = tx.QueryRow(ctx,
 		`INSERT INTO entries (entry_type, title) VALUES ('todo', $1) RETURNING id`,
 		title,
 	).Scan(&id)
 	if err != nil {
 		return "", err
 	}
-	return id, nil
+
+	if err := entries.LinkTitle(ctx, tx, id, title); err != nil {
+		return "", err
+	}
+	return id, tx.Commit(ctx)
+}
+
+const itemColumns = `i.id, i.entry_id, i.parent_id, i.body, i.is_done, i.position, i.created_at,
+            i.due_date, to_char(i.due_time, 'HH24:MI'), i.tags, i.priority, i.context`
+
+func itemFields(ti *TodoItem) []any {
+	return []any{&ti.ID, &ti.EntryID, &ti.ParentID, &ti.Body, &ti.IsDone, &ti.Position, &ti.CreatedAt,
+		&ti.DueDate, &ti.DueTime, &ti.Tags, &ti.Priority, &ti.Context}
 }
 
 func GetByID(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry, []TodoItem, error) {
@@ -34,20 +69,20 @@ func GetByID(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry,
 	var t []TodoItem
 
 	err := pool.QueryRow(ctx,
-		`SELECT id, entry_type, title, created_at, updated_at
+		`SELECT id, entry_type, title, created_at, updated_at, version
 		 FROM entries
 		 WHERE id = $1`,
 		id,
-	).Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt)
+	).Scan(&e.ID, &e.EntryType, &e.Title, &e.CreatedAt, &e.UpdatedAt, &e.Version)
 	if err != nil {
 		return e, nil, err
 	}
 
 	rows, err := pool.Query(ctx,
-		`SELECT id, entry_id, body, is_done, position, created_at
-   		 FROM todo_items
-         WHERE entry_id = $1
-         ORDER BY position`,
+		`SELECT `+itemColumns+`
+   		 FROM todo_items i
+         WHERE i.entry_id = $1
+         ORDER BY i.position`,
 		id)
 	if err != nil {
 		return e, nil, err
@@ -56,8 +91,7 @@ func GetByID(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry,
 
 	for rows.Next() {
 		var ti TodoItem
-		err := rows.Scan(&ti.ID, &ti.EntryID, &ti.Body, &ti.IsDone, &ti.Position, &ti.CreatedAt)
-		if err != nil {
+		if err := rows.Scan(itemFields(&ti)...); err != nil {
 			return e, nil, err
 		}
 		t = append(t, ti)
@@ -66,32 +100,288 @@ func GetByID(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry,
 	return e, t, err
 }
 
-func Update(ctx context.Context, pool *pgxpool.Pool, id, title string) error {
-	_, err := pool.Exec(ctx,
-		`UPDATE entries SET title = $1, updated_at = now() WHERE id = $2`,
-		title, id,
-	)
-	return err
+// Update saves a todo list loaded at version. It returns
+// entries.ErrConflict if the list has been saved since.
+func Update(ctx context.Context, pool *pgxpool.Pool, id, title string, version int) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := entries.UpdateTitle(ctx, tx, id, title, version); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
 }
 
-func AddItem(ctx context.Context, pool *pgxpool.Pool, entryID string, body string) error {
-	_, err := pool.Exec(ctx,
-		`INSERT INTO todo_items (entry_id, body, position)
-         VALUES ($1, $2, COALESCE((SELECT MAX(position)
+// AddItem appends an item to its list, or to the sub-items of its parent
+// if ParentID is set. DueDate and DueTime may be nil.
+func AddItem(ctx context.Context, pool *pgxpool.Pool, item TodoItem) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockList(ctx, tx, item.EntryID); err != nil {
+		return err
+	}
+
+	// A sub-item must be under an item of the same list.
+	var id string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO todo_items (entry_id, parent_id, body, position, due_date, due_time, tags, priority, context)
+         SELECT $1::uuid, $2::uuid, $3::text, COALESCE((SELECT MAX(position)
          FROM todo_items
-         WHERE entry_id = $1), 0) + 1)`,
-		entryID, body,
+         WHERE entry_id = $1 AND parent_id IS NOT DISTINCT FROM $2::uuid), 0) + 1, $4::date, $5::time,
+         COALESCE($6::text[], '{}'), $7::text, $8::text
+         WHERE $2::uuid IS NULL OR EXISTS (SELECT 1 FROM todo_items WHERE id = $2 AND entry_id = $1)
+         RETURNING id`,
+		item.EntryID, item.ParentID, item.Body, item.DueDate, item.DueTime, item.Tags, item.Priority, item.Context,
+	).Scan(&id)
+	if err != nil {
+		return err
+	}
+
+	// A new open sub-item reopens its parents.
+	if err := rollUp(ctx, tx, item.ParentID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// lockList holds the list's entry row until tx ends, so that changes to the
+// order of its items happen one at a time.
+func lockList(ctx context.Context, tx pgx.Tx, entryID string) error {
+	var id string
+	return tx.QueryRow(ctx,
+		`SELECT id FROM entries WHERE id = $1 AND entry_type IN ('todo', 'todo_template') FOR UPDATE`,
+		entryID,
+	).Scan(&id)
+}
+
+// MoveItem moves an item to index, counted from 0, in its list. The other
+// items keep their order around it.
+func MoveItem(ctx context.Context, pool *pgxpool.Pool, entryID, itemID string, index int) error {
+	return moveItem(ctx, pool, entryID, itemID, func(int) int { return index })
+}
+
+// ShiftItem moves an item by places, up the list if negative.
+func ShiftItem(ctx context.Context, pool *pgxpool.Pool, entryID, itemID string, places int) error {
+	return moveItem(ctx, pool, entryID, itemID, func(from int) int { return from + places })
+}
+
+func moveItem(ctx context.Context, pool *pgxpool.Pool, entryID, itemID string, target func(from int) int) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := lockList(ctx, tx, entryID); err != nil {
+		return err
+	}
+
+	// Only the item's siblings take part in the move.
+	rows, err := tx.Query(ctx,
+		`SELECT id FROM todo_items
+         WHERE entry_id = $1
+           AND parent_id IS NOT DISTINCT FROM (SELECT parent_id FROM todo_items WHERE id = $2)
+         ORDER BY position`,
+		entryID, itemID,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+	var ids []string
+	from := -1
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		if id == itemID {
+			from = len(ids)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if from < 0 {
+		return pgx.ErrNoRows
+	}
+
+	to := min(max(target(from), 0), len(ids))
+	if to == from {
+		return nil
+	}
+	ids = slices.Insert(ids, to, itemID)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE todo_items t
+         SET position = o.position
+         FROM unnest($1::text[]) WITH ORDINALITY AS o(id, position)
+         WHERE t.id = o.id::uuid AND t.position <> o.position`,
+		ids,
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
-func ToggleItem(ctx context.Context, pool *pgxpool.Pool, itemID string) error {
-	_, err := pool.Exec(ctx,
+// ToggleItem checks or unchecks an item, and with cascade all the items
+// below it too; without it the sub-items are left as they are. The item's
+// parents are then done exactly when all their sub-items are.
+func ToggleItem(ctx context.Context, pool *pgxpool.Pool, itemID string, cascade bool) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var entryID string
+	err = tx.QueryRow(ctx, `SELECT entry_id FROM todo_items WHERE id = $1`, itemID).Scan(&entryID)
+	if err != nil {
+		return err
+	}
+	if err := lockList(ctx, tx, entryID); err != nil {
+		return err
+	}
+
+	var done bool
+	var parentID *string
+	err = tx.QueryRow(ctx,
 		`UPDATE todo_items
 		 SET is_done = NOT is_done
-		 WHERE id = $1`,
+		 WHERE id = $1
+		 RETURNING is_done, parent_id`,
 		itemID,
+	).Scan(&done, &parentID)
+	if err != nil {
+		return err
+	}
+
+	if cascade {
+		_, err = tx.Exec(ctx,
+			`WITH RECURSIVE below AS (
+                 SELECT id FROM todo_items WHERE parent_id = $1
+                 UNION ALL
+                 SELECT t.id FROM todo_items t JOIN below b ON t.parent_id = b.id
+             )
+             UPDATE todo_items SET is_done = $2 WHERE id IN (SELECT id FROM below)`,
+			itemID, done,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := rollUp(ctx, tx, parentID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// rollUp marks parentID and each item above it done if all its sub-items
+// are, and open otherwise, working up from the nearest. An item left with
+// no sub-items keeps its state.
+func rollUp(ctx context.Context, tx pgx.Tx, parentID *string) error {
+	if parentID == nil {
+		return nil
+	}
+	rows, err := tx.Query(ctx,
+		`WITH RECURSIVE above AS (
+             SELECT $1::uuid AS id, 0 AS depth
+             UNION ALL
+             SELECT t.parent_id, a.depth + 1 FROM todo_items t JOIN above a ON t.id = a.id
+         )
+         SELECT id::text FROM above WHERE id IS NOT NULL ORDER BY depth`,
+		*parentID,
+	)
+	if err != nil {
+		return err
+	}
+	var parents []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		parents = append(parents, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range parents {
+		_, err := tx.Exec(ctx,
+			`UPDATE todo_items p
+             SET is_done = COALESCE((SELECT bool_and(c.is_done) FROM todo_items c WHERE c.parent_id = p.id), p.is_done)
+             WHERE p.id = $1`,
+			id,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Tree arranges items, in position order, under their parents and returns
+// the top-level ones.
+func Tree(items []TodoItem) []TodoItem {
+	children := map[string][]TodoItem{}
+	for _, item := range items {
+		parent := ""
+		if item.ParentID != nil {
+			parent = *item.ParentID
+		}
+		children[parent] = append(children[parent], item)
+	}
+
+	var build func(parent string) []TodoItem
+	build = func(parent string) []TodoItem {
+		list := children[parent]
+		for i := range list {
+			list[i].Children = build(list[i].ID)
+		}
+		return list
+	}
+	return build("")
+}
+
+// ChildrenDone counts the item's sub-items that are done.
+func (t TodoItem) ChildrenDone() int {
+	n := 0
+	for _, c := range t.Children {
+		if c.IsDone {
+			n++
+		}
+	}
+	return n
+}
+
+// SetItemDue sets or, with a nil dueDate, clears when an item is due. A
+// changed due date means a new reminder.
+func SetItemDue(ctx context.Context, pool *pgxpool.Pool, itemID string, dueDate *time.Time, dueTime *string) error {
+	tag, err := pool.Exec(ctx,
+		`UPDATE todo_items
+         SET due_date = $2, due_time = $3::time, reminded_at = NULL
+         WHERE id = $1`,
+		itemID, dueDate, dueTime,
 	)
+	if err == nil && tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
 	return err
 }
 
@@ -103,12 +393,31 @@ func UpdateItem(ctx context.Context, pool *pgxpool.Pool, itemID string, body str
 	return err
 }
 
+// DeleteItem removes an item with everything below it, and rolls the
+// change up to its parents.
 func DeleteItem(ctx context.Context, pool *pgxpool.Pool, itemID string) error {
-	_, err := pool.Exec(ctx,
-		`DELETE FROM todo_items WHERE id = $1`,
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	var parentID *string
+	err = tx.QueryRow(ctx,
+		`DELETE FROM todo_items WHERE id = $1 RETURNING parent_id`,
 		itemID,
-	)
-	return err
+	).Scan(&parentID)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := rollUp(ctx, tx, parentID); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
 }
 
 func Delete(ctx context.Context, pool *pgxpool.Pool, id string) error {
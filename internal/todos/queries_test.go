@@ -0,0 +1,129 @@
+package todos
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/database"
+)
+
+// testPool connects to the database in DATABASE_URL and brings it up to
+// date, or skips the test if none is given.
+func testPool(t *testing.T) *pgxpool.Pool {
+	t.Helper()
+	if os.Getenv("DATABASE_URL") == "" {
+		t.Skip("DATABASE_URL not set")
+	}
+	ctx := context.Background()
+	pool, err := database.Connect(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(pool.Close)
+	if err := database.RunMigrations(ctx, pool, "../../migrations"); err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+// testList makes a list holding a parent item with two sub-items, and
+// returns the list's ID.
+func testList(t *testing.T, pool *pgxpool.Pool) string {
+	t.Helper()
+	ctx := context.Background()
+	id, err := Create(ctx, pool, "Toggle test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Delete(context.Background(), pool, id) })
+
+	if err := AddItem(ctx, pool, TodoItem{EntryID: id, Body: "parent"}); err != nil {
+		t.Fatal(err)
+	}
+	parent := itemsByBody(t, pool, id)["parent"]
+	for _, body := range []string{"first", "second"} {
+		if err := AddItem(ctx, pool, TodoItem{EntryID: id, ParentID: &parent.ID, Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return id
+}
+
+func itemsByBody(t *testing.T, pool *pgxpool.Pool, id string) map[string]TodoItem {
+	t.Helper()
+	_, items, err := GetByID(context.Background(), pool, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	byBody := map[string]TodoItem{}
+	for _, item := range items {
+		byBody[item.Body] = item
+	}
+	return byBody
+}
+
+// done reports which items of the list are done, by body.
+func done(t *testing.T, pool *pgxpool.Pool, id string) map[string]bool {
+	t.Helper()
+	states := map[string]bool{}
+	for body, item := range itemsByBody(t, pool, id) {
+		states[body] = item.IsDone
+	}
+	return states
+}
+
+func TestToggleItem(t *testing.T) {
+	pool := testPool(t)
+	ctx := context.Background()
+
+	toggle := func(id, body string, cascade bool) {
+		t.Helper()
+		item := itemsByBody(t, pool, id)[body]
+		if err := ToggleItem(ctx, pool, item.ID, cascade); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check := func(id, step string, want map[string]bool) {
+		t.Helper()
+		got := done(t, pool, id)
+		for body, w := range want {
+			if got[body] != w {
+				t.Errorf("%s: %q done = %t, want %t", step, body, got[body], w)
+			}
+		}
+	}
+
+	t.Run("children roll up", func(t *testing.T) {
+		id := testList(t, pool)
+		toggle(id, "first", false)
+		check(id, "first checked", map[string]bool{"parent": false, "first": true, "second": false})
+		toggle(id, "second", false)
+		check(id, "both checked", map[string]bool{"parent": true, "first": true, "second": true})
+	})
+
+	t.Run("uncheck keeps children", func(t *testing.T) {
+		id := testList(t, pool)
+		toggle(id, "first", false)
+		toggle(id, "second", false)
+		toggle(id, "parent", false)
+		check(id, "parent unchecked", map[string]bool{"parent": false, "first": true, "second": true})
+	})
+
+	t.Run("check without cascade", func(t *testing.T) {
+		id := testList(t, pool)
+		toggle(id, "first", false)
+		toggle(id, "parent", false)
+		check(id, "parent checked", map[string]bool{"parent": true, "first": true, "second": false})
+	})
+
+	t.Run("cascade", func(t *testing.T) {
+		id := testList(t, pool)
+		toggle(id, "first", false)
+		toggle(id, "parent", true)
+		check(id, "all checked", map[string]bool{"parent": true, "first": true, "second": true})
+		toggle(id, "parent", true)
+		check(id, "all unchecked", map[string]bool{"parent": false, "first": false, "second": false})
+	})
+}
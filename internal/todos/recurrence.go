@@ -0,0 +1,128 @@
+package todos
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Recurrence is how often a todo template makes a new list by itself. It
+// is stored as "", "daily", "weekly:<weekday>" with 0 for Sunday, or
+// "monthly:<day of month>".
+type Recurrence struct {
+	Kind    string // "", "daily", "weekly" or "monthly"
+	Weekday time.Weekday
+	Day     int
+}
+
+func ParseRecurrence(s string) (Recurrence, error) {
+	kind, arg, _ := strings.Cut(s, ":")
+	switch kind {
+	case "", "daily":
+		return Recurrence{Kind: kind}, nil
+	case "weekly":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 || n > 6 {
+			return Recurrence{}, fmt.Errorf("invalid weekday %q", arg)
+		}
+		return Recurrence{Kind: kind, Weekday: time.Weekday(n)}, nil
+	case "monthly":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 1 || n > 31 {
+			return Recurrence{}, fmt.Errorf("invalid day of month %q", arg)
+		}
+		return Recurrence{Kind: kind, Day: n}, nil
+	}
+	return Recurrence{}, fmt.Errorf("invalid recurrence %q", s)
+}
+
+func (r Recurrence) String() string {
+	switch r.Kind {
+	case "weekly":
+		return fmt.Sprintf("weekly:%d", r.Weekday)
+	case "monthly":
+		return fmt.Sprintf("monthly:%d", r.Day)
+	}
+	return r.Kind
+}
+
+func (r Recurrence) Label() string {
+	switch r.Kind {
+	case "daily":
+		return "Every day"
+	case "weekly":
+		return "Every " + r.Weekday.String()
+	case "monthly":
+		return "On day " + strconv.Itoa(r.Day) + " of every month"
+	}
+	return "Never"
+}
+
+// Next returns the first date after day that the recurrence falls on, or
+// the zero time if it never does. A monthly day past the end of a short
+// month falls on that month's last day.
+func (r Recurrence) Next(day time.Time) time.Time {
+	y, m, d := day.Date()
+	switch r.Kind {
+	case "daily":
+		return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+	case "weekly":
+		ahead := (int(r.Weekday)-int(day.Weekday())+6)%7 + 1
+		return time.Date(y, m, d+ahead, 0, 0, 0, 0, time.UTC)
+	case "monthly":
+		if next := monthDay(y, m, r.Day); next.After(time.Date(y, m, d, 0, 0, 0, 0, time.UTC)) {
+			return next
+		}
+		return monthDay(y, m+1, r.Day)
+	}
+	return time.Time{}
+}
+
+func monthDay(y int, m time.Month, day int) time.Time {
+	last := time.Date(y, m+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	return time.Date(y, m, min(day, last), 0, 0, 0, 0, time.UTC)
+}
+
+// Recurrer makes new lists from todo templates whose recurrence has come
+// round. Templates are locked while a list is made from them, so several
+// server instances never make the same list twice.
+type Recurrer struct {
+	pool *pgxpool.Pool
+	Poll time.Duration // how often to look for templates that are due
+}
+
+func NewRecurrer(pool *pgxpool.Pool) *Recurrer {
+	return &Recurrer{pool: pool, Poll: 10 * time.Minute}
+}
+
+// Run blocks until ctx is cancelled.
+func (r *Recurrer) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Poll)
+	defer ticker.Stop()
+
+	for {
+		for {
+			made, err := runDueTemplate(ctx, r.pool, time.Now())
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Println("recurring todos:", err)
+				}
+				break
+			}
+			if !made {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
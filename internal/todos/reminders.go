@@ -0,0 +1,154 @@
+package todos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Notifier delivers reminders of items that are falling due.
+type Notifier interface {
+	Notify(ctx context.Context, item DueItem) error
+}
+
+// LogNotifier writes reminders to the server log.
+type LogNotifier struct{}
+
+func (LogNotifier) Notify(ctx context.Context, item DueItem) error {
+	log.Printf("reminder: %q on %q is due %s (%s)", item.Body, item.ListTitle, item.DueLabel(), item.Path())
+	return nil
+}
+
+// WebhookNotifier posts each reminder as JSON to URL. BaseURL, if set, is
+// put in front of the item's path to make a link.
+type WebhookNotifier struct {
+	URL     string
+	BaseURL string
+	Client  *http.Client
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, item DueItem) error {
+	payload, err := json.Marshal(map[string]any{
+		"list":     item.ListTitle,
+		"item":     item.Body,
+		"due_date": item.DueDate.Format(time.DateOnly),
+		"due_time": item.DueTime,
+		"url":      n.BaseURL + item.Path(),
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Reminders sends a reminder for each open item once it is within Lead of
+// falling due. Items due on a date without a time are reminded of at the
+// start of that day.
+type Reminders struct {
+	pool     *pgxpool.Pool
+	notifier Notifier
+	Lead     time.Duration // how long before an item is due to remind
+	Poll     time.Duration // how often to look for due items
+}
+
+func NewReminders(pool *pgxpool.Pool, notifier Notifier, lead time.Duration) *Reminders {
+	return &Reminders{
+		pool:     pool,
+		notifier: notifier,
+		Lead:     lead,
+		Poll:     time.Minute,
+	}
+}
+
+// Run blocks until ctx is cancelled.
+func (r *Reminders) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.Poll)
+	defer ticker.Stop()
+
+	for {
+		if err := r.send(ctx); err != nil && ctx.Err() == nil {
+			log.Println("reminders:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reminders) send(ctx context.Context) error {
+	due, err := claimReminders(ctx, r.pool, time.Now().Add(r.Lead))
+	if err != nil {
+		return err
+	}
+
+	for _, item := range due {
+		if err := r.notifier.Notify(ctx, item); err != nil {
+			log.Printf("reminders: item %s: %v", item.ID, err)
+			// Try again on the next poll.
+			if err := unclaimReminder(context.WithoutCancel(ctx), r.pool, item.ID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// claimReminders marks the open items due by until as reminded and returns
+// them. Rows being claimed by another instance are skipped.
+func claimReminders(ctx context.Context, pool *pgxpool.Pool, until time.Time) ([]DueItem, error) {
+	rows, err := pool.Query(ctx,
+		`UPDATE todo_items i
+         SET reminded_at = now()
+         FROM entries e
+         WHERE e.id = i.entry_id
+           AND i.id IN (
+               SELECT id FROM todo_items
+               WHERE due_date IS NOT NULL AND NOT is_done AND reminded_at IS NULL
+                 AND due_date + COALESCE(due_time, '00:00') <= $1::timestamp
+               ORDER BY due_date, due_time
+               LIMIT 100
+               FOR UPDATE SKIP LOCKED)
+         RETURNING e.title, `+itemColumns,
+		until.Format("2006-01-02 15:04:05"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanDueItems(rows)
+}
+
+func unclaimReminder(ctx context.Context, pool *pgxpool.Pool, itemID string) error {
+	_, err := pool.Exec(ctx,
+		`UPDATE todo_items SET reminded_at = NULL WHERE id = $1`,
+		itemID,
+	)
+	return err
+}
@@ -0,0 +1,216 @@
+package todos
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nemouu/cairn/internal/entries"
+)
+
+// Template is a todo template: an entry of type todo_template whose items
+// are copied into every list made from it.
+type Template struct {
+	EntryID    string
+	Recurrence Recurrence
+	// NextRunOn is the date the next list is made on, if the template
+	// recurs.
+	NextRunOn *time.Time
+}
+
+func CreateTemplate(ctx context.Context, pool *pgxpool.Pool, title string, rec Recurrence) (string, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	var id string
+	err = tx.QueryRow(ctx,
+		`INSERT INTO entries (entry_type, title) VALUES ('todo_template', $1) RETURNING id`,
+		title,
+	).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tx.Exec(ctx,
+		`INSERT INTO todo_templates (entry_id, recurrence, next_run_on) VALUES ($1, $2, $3)`,
+		id, rec.String(), nextRun(rec, time.Now()),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	if err := entries.LinkTitle(ctx, tx, id, title); err != nil {
+		return "", err
+	}
+	return id, tx.Commit(ctx)
+}
+
+// nextRun is the first date after day that rec makes a list on, or nil.
+func nextRun(rec Recurrence, day time.Time) *time.Time {
+	next := rec.Next(day)
+	if next.IsZero() {
+		return nil
+	}
+	return &next
+}
+
+func GetTemplate(ctx context.Context, pool *pgxpool.Pool, id string) (entries.Entry, Template, []TodoItem, error) {
+	var t Template
+	e, items, err := GetByID(ctx, pool, id)
+	if err != nil {
+		return e, t, nil, err
+	}
+
+	var rec string
+	err = pool.QueryRow(ctx,
+		`SELECT entry_id, recurrence, next_run_on FROM todo_templates WHERE entry_id = $1`,
+		id,
+	).Scan(&t.EntryID, &rec, &t.NextRunOn)
+	if err != nil {
+		return e, t, nil, err
+	}
+	t.Recurrence, err = ParseRecurrence(rec)
+	return e, t, items, err
+}
+
+// UpdateTemplate saves a template loaded at version, like Update. The next
+// run is only rescheduled if the recurrence changed.
+func UpdateTemplate(ctx context.Context, pool *pgxpool.Pool, id, title string, rec Recurrence, version int) error {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := entries.UpdateTitle(ctx, tx, id, title, version); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(ctx,
+		`UPDATE todo_templates
+         SET next_run_on = CASE WHEN recurrence = $2 THEN next_run_on ELSE $3::date END,
+             recurrence = $2
+         WHERE entry_id = $1`,
+		id, rec.String(), nextRun(rec, time.Now()),
+	)
+	if err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// Instantiate makes a new todo list with a copy of a template's items and
+// returns its ID.
+func Instantiate(ctx context.Context, pool *pgxpool.Pool, templateID, title string) (string, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	id, err := instantiate(ctx, tx, templateID, title)
+	if err != nil {
+		return "", err
+	}
+	return id, tx.Commit(ctx)
+}
+
+func instantiate(ctx context.Context, tx pgx.Tx, templateID, title string) (string, error) {
+	var id string
+	err := tx.QueryRow(ctx,
+		`INSERT INTO entries (entry_type, title)
+         SELECT 'todo', $2::text
+         FROM entries
+         WHERE id = $1 AND entry_type = 'todo_template'
+         RETURNING id`,
+		templateID, title,
+	).Scan(&id)
+	if err != nil {
+		return "", err
+	}
+
+	// Items get new IDs up front so sub-items can be put under the copies
+	// of their parents.
+	_, err = tx.Exec(ctx,
+		`WITH src AS (
+             SELECT id, parent_id, body, position, tags, priority, context, gen_random_uuid() AS new_id
+             FROM todo_items
+             WHERE entry_id = $2
+         )
+         INSERT INTO todo_items (id, entry_id, parent_id, body, position, tags, priority, context)
+         SELECT s.new_id, $1::uuid, p.new_id, s.body,
+                row_number() OVER (PARTITION BY s.parent_id ORDER BY s.position),
+                s.tags, s.priority, s.context
+         FROM src s
+         LEFT JOIN src p ON p.id = s.parent_id`,
+		id, templateID,
+	)
+	if err != nil {
+		return "", err
+	}
+	return id, entries.LinkTitle(ctx, tx, id, title)
+}
+
+// InstanceTitle is the title of a list made from a template on day.
+func InstanceTitle(templateTitle string, day time.Time) string {
+	return templateTitle + " – " + day.Format("Mon 2 Jan 2006")
+}
+
+// runDueTemplate makes one list from a recurring template whose next run
+// date has come, and schedules its next run. Runs missed while the server
+// was down are made up for by a single list. It reports whether there was
+// a template to run.
+func runDueTemplate(ctx context.Context, pool *pgxpool.Pool, now time.Time) (bool, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback(ctx)
+
+	var id, title, rec string
+	err = tx.QueryRow(ctx,
+		`SELECT t.entry_id, e.title, t.recurrence
+         FROM todo_templates t
+         JOIN entries e ON e.id = t.entry_id
+         WHERE t.next_run_on <= $1::date
+         ORDER BY t.next_run_on
+         LIMIT 1
+         FOR UPDATE OF t SKIP LOCKED`,
+		now.Format(time.DateOnly),
+	).Scan(&id, &title, &rec)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	listID, err := instantiate(ctx, tx, id, InstanceTitle(title, now))
+	if err != nil {
+		return false, err
+	}
+
+	recurrence, err := ParseRecurrence(rec)
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(ctx,
+		`UPDATE todo_templates SET next_run_on = $2 WHERE entry_id = $1`,
+		id, nextRun(recurrence, now),
+	)
+	if err != nil {
+		return false, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return false, err
+	}
+	log.Printf("recurring todos: made %s from template %s", listID, id)
+	return true, nil
+}
@@ -0,0 +1,3 @@
+ALTER TABLE bookmarks ADD COLUMN check_claimed_until TIMESTAMPTZ;
+
+CREATE INDEX idx_bookmarks_last_checked_at ON bookmarks (last_checked_at NULLS FIRST);
@@ -0,0 +1,14 @@
+CREATE TABLE bookmark_checks (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    entry_id     UUID NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    checked_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    status       INTEGER NOT NULL,
+    latency_ms   INTEGER NOT NULL,
+    final_url    TEXT,
+    content_hash TEXT,
+    error_class  TEXT
+);
+
+CREATE INDEX idx_bookmark_checks_entry ON bookmark_checks (entry_id, checked_at DESC);
+
+ALTER TABLE bookmarks ADD COLUMN failing_since TIMESTAMPTZ;
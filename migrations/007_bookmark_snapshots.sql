@@ -0,0 +1,15 @@
+CREATE TABLE bookmark_snapshots (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    entry_id     UUID NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    taken_at     TIMESTAMPTZ NOT NULL DEFAULT now(),
+    content_hash TEXT NOT NULL,
+    body         TEXT NOT NULL
+);
+
+CREATE INDEX idx_bookmark_snapshots_entry ON bookmark_snapshots (entry_id, taken_at DESC);
+
+ALTER TABLE bookmarks ADD COLUMN content_changed_at TIMESTAMPTZ;
+
+-- Hashes are now taken over normalized text, so the raw body hashes stored
+-- so far can't be compared against new ones.
+UPDATE bookmarks SET content_hash = NULL;
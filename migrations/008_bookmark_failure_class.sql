@@ -0,0 +1 @@
+ALTER TABLE bookmarks ADD COLUMN last_error_class TEXT;
@@ -0,0 +1,3 @@
+ALTER TABLE bookmark_checks ADD COLUMN redirects JSONB;
+
+ALTER TABLE bookmarks ADD COLUMN suggested_url TEXT;
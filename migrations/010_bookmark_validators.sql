@@ -0,0 +1,3 @@
+ALTER TABLE bookmarks ADD COLUMN etag TEXT;
+
+ALTER TABLE bookmarks ADD COLUMN last_modified TEXT;
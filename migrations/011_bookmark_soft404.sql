@@ -0,0 +1,3 @@
+ALTER TABLE bookmark_checks ADD COLUMN soft404_confidence REAL;
+
+ALTER TABLE bookmarks ADD COLUMN soft404_confidence REAL;
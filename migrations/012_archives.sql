@@ -0,0 +1,16 @@
+CREATE TABLE archive_blobs (
+    key  TEXT PRIMARY KEY,
+    data BYTEA NOT NULL
+);
+
+CREATE TABLE bookmark_archives (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    entry_id     UUID NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    content_hash TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size         INTEGER NOT NULL,
+    blob_key     TEXT NOT NULL
+);
+
+CREATE INDEX idx_bookmark_archives_entry ON bookmark_archives (entry_id, created_at DESC);
@@ -0,0 +1,7 @@
+ALTER TABLE bookmarks ADD COLUMN page_title TEXT;
+ALTER TABLE bookmarks ADD COLUMN description TEXT;
+ALTER TABLE bookmarks ADD COLUMN site_name TEXT;
+ALTER TABLE bookmarks ADD COLUMN image_url TEXT;
+ALTER TABLE bookmarks ADD COLUMN canonical_url TEXT;
+ALTER TABLE bookmarks ADD COLUMN favicon_url TEXT;
+ALTER TABLE bookmarks ADD COLUMN lang TEXT;
@@ -0,0 +1,4 @@
+-- Filled in by the server at startup, since normalization happens in Go.
+ALTER TABLE bookmarks ADD COLUMN normalized_url TEXT;
+
+CREATE INDEX idx_bookmarks_normalized_url ON bookmarks (normalized_url);
@@ -0,0 +1,7 @@
+ALTER TABLE bookmarks ADD COLUMN cert_issuer TEXT;
+ALTER TABLE bookmarks ADD COLUMN cert_subject TEXT;
+ALTER TABLE bookmarks ADD COLUMN cert_sans TEXT[];
+ALTER TABLE bookmarks ADD COLUMN cert_not_after TIMESTAMPTZ;
+
+CREATE INDEX idx_bookmarks_cert_not_after ON bookmarks (cert_not_after)
+    WHERE cert_not_after IS NOT NULL;
@@ -0,0 +1,16 @@
+CREATE TABLE note_revisions (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    entry_id   UUID NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    title      TEXT NOT NULL,
+    body       TEXT NOT NULL,
+    size_delta INTEGER NOT NULL
+);
+
+CREATE INDEX idx_note_revisions_entry ON note_revisions (entry_id, created_at DESC);
+
+-- Existing notes start their history at their current state.
+INSERT INTO note_revisions (entry_id, created_at, title, body, size_delta)
+SELECT n.entry_id, e.updated_at, e.title, n.body, length(n.body)
+FROM notes n
+JOIN entries e ON e.id = n.entry_id;
@@ -0,0 +1,25 @@
+-- One row per distinct file content, however many entries it is attached to.
+CREATE TABLE attachment_blobs (
+    sha256     TEXT PRIMARY KEY,
+    size       BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE attachments (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    entry_id     UUID NOT NULL REFERENCES entries(id) ON DELETE CASCADE,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT now(),
+    filename     TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    size         BIGINT NOT NULL,
+    sha256       TEXT NOT NULL REFERENCES attachment_blobs (sha256)
+);
+
+CREATE INDEX idx_attachments_entry ON attachments (entry_id, created_at);
+CREATE INDEX idx_attachments_sha256 ON attachments (sha256);
+
+-- Where the large object store keeps each blob.
+CREATE TABLE attachment_objects (
+    key TEXT PRIMARY KEY,
+    oid OID NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Earlier concurrent adds could share a position; number each list 1..n.
+UPDATE todo_items t
+SET position = o.rn
+FROM (
+    SELECT id, row_number() OVER (PARTITION BY entry_id ORDER BY position, created_at) AS rn
+    FROM todo_items
+) o
+WHERE t.id = o.id;
+
+-- Deferred so that renumbering a list in one transaction can pass through
+-- duplicate positions on the way.
+ALTER TABLE todo_items
+    ADD CONSTRAINT todo_items_entry_position_key UNIQUE (entry_id, position)
+    DEFERRABLE INITIALLY DEFERRED;
+
+DROP INDEX idx_todo_items_entry;
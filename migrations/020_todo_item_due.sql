@@ -0,0 +1,6 @@
+ALTER TABLE todo_items ADD COLUMN due_date DATE;
+ALTER TABLE todo_items ADD COLUMN due_time TIME;
+ALTER TABLE todo_items ADD COLUMN reminded_at TIMESTAMPTZ;
+
+CREATE INDEX idx_todo_items_due ON todo_items (due_date, due_time)
+    WHERE due_date IS NOT NULL AND NOT is_done;
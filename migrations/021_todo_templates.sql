@@ -0,0 +1,13 @@
+ALTER TABLE entries DROP CONSTRAINT entries_entry_type_check;
+ALTER TABLE entries ADD CONSTRAINT entries_entry_type_check
+    CHECK (entry_type IN ('note', 'bookmark', 'todo', 'todo_template'));
+
+-- A todo template keeps its items in todo_items like a list does.
+CREATE TABLE todo_templates (
+    entry_id    UUID PRIMARY KEY REFERENCES entries(id) ON DELETE CASCADE,
+    recurrence  TEXT NOT NULL DEFAULT '',
+    next_run_on DATE
+);
+
+CREATE INDEX idx_todo_templates_next_run ON todo_templates (next_run_on)
+    WHERE next_run_on IS NOT NULL;
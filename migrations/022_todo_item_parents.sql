@@ -0,0 +1,11 @@
+ALTER TABLE todo_items
+    ADD COLUMN parent_id UUID REFERENCES todo_items(id) ON DELETE CASCADE;
+
+-- Positions now count among the items under the same parent. Top-level
+-- items have no parent and must still not share a position.
+ALTER TABLE todo_items DROP CONSTRAINT todo_items_entry_position_key;
+ALTER TABLE todo_items
+    ADD CONSTRAINT todo_items_parent_position_key UNIQUE NULLS NOT DISTINCT (entry_id, parent_id, position)
+    DEFERRABLE INITIALLY DEFERRED;
+
+CREATE INDEX idx_todo_items_parent ON todo_items(parent_id) WHERE parent_id IS NOT NULL;
@@ -0,0 +1,4 @@
+ALTER TABLE todo_items
+    ADD COLUMN tags     TEXT[] NOT NULL DEFAULT '{}',
+    ADD COLUMN priority TEXT NOT NULL DEFAULT '' CHECK (priority IN ('', 'high', 'medium', 'low')),
+    ADD COLUMN context  TEXT NOT NULL DEFAULT '';
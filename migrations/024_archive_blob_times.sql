@@ -0,0 +1,3 @@
+-- When each copy was last stored, so a sweep leaves copies alone whose
+-- archive records may not be written yet.
+ALTER TABLE archive_blobs ADD COLUMN stored_at TIMESTAMPTZ NOT NULL DEFAULT now();
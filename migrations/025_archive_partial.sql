@@ -0,0 +1,3 @@
+-- Archived copies that left out stylesheets or images because capturing
+-- the page ran out of time or budget.
+ALTER TABLE bookmark_archives ADD COLUMN partial BOOLEAN NOT NULL DEFAULT false;
@@ -0,0 +1,138 @@
+// Reordering of todo items by dragging, or with Alt+Up/Down on an item's
+// handle. Without this script the up and down buttons do the same. Each
+// list of sub-items is sorted on its own; items never change parent.
+(function () {
+    for (const list of document.querySelectorAll(".todo-items")) {
+        sortable(list);
+    }
+    rememberCollapsed();
+
+    function sortable(list) {
+        list.classList.add("sortable");
+
+        const items = () => Array.from(list.querySelectorAll(":scope > .todo-item"));
+
+        // save sends the item's new place, reloading to show the saved order if
+        // the server refuses it.
+        function save(item) {
+            const body = new URLSearchParams({ index: items().indexOf(item) });
+            fetch(list.dataset.list + "/" + item.dataset.item + "/move", {
+                method: "POST",
+                headers: { "X-Requested-With": "fetch" },
+                body: body,
+            })
+                .then((res) => {
+                    if (!res.ok) {
+                        location.reload();
+                    }
+                })
+                .catch(() => location.reload());
+        }
+
+        let dragged = null;
+        let startIndex = -1;
+
+        for (const item of items()) {
+            const handle = item.querySelector(":scope > .drag-handle");
+
+            // Only the handle starts a drag, so text in the item stays selectable.
+            handle.addEventListener("mousedown", () => (item.draggable = true));
+            handle.addEventListener("touchstart", () => (item.draggable = true), { passive: true });
+
+            item.addEventListener("dragstart", (e) => {
+                // Nested lists see the drag too; only the innermost takes it.
+                e.stopPropagation();
+                dragged = item;
+                startIndex = items().indexOf(item);
+                item.classList.add("dragging");
+                e.dataTransfer.effectAllowed = "move";
+                e.dataTransfer.setData("text/plain", item.dataset.item);
+            });
+
+            // The item is moved while dragging over the list, so wherever the
+            // drag ends, its place then is where it goes.
+            item.addEventListener("dragend", (e) => {
+                e.stopPropagation();
+                item.classList.remove("dragging");
+                item.draggable = false;
+                dragged = null;
+                if (items().indexOf(item) !== startIndex) {
+                    save(item);
+                }
+            });
+
+            handle.addEventListener("keydown", (e) => {
+                // Keep the handle's own item moving, not its parent.
+                e.stopPropagation();
+                if (!e.altKey || (e.key !== "ArrowUp" && e.key !== "ArrowDown")) {
+                    return;
+                }
+                e.preventDefault();
+                if (e.key === "ArrowUp" && item.previousElementSibling) {
+                    list.insertBefore(item, item.previousElementSibling);
+                } else if (e.key === "ArrowDown" && item.nextElementSibling) {
+                    list.insertBefore(item.nextElementSibling, item);
+                } else {
+                    return;
+                }
+                handle.focus();
+                save(item);
+            });
+        }
+
+        list.addEventListener("dragover", (e) => {
+            if (!dragged) {
+                return;
+            }
+            e.preventDefault();
+            e.dataTransfer.dropEffect = "move";
+
+            e.stopPropagation();
+
+            // Find the item of this list the pointer is over, which may be the
+            // parent of a nested item under it.
+            let over = e.target.closest(".todo-item");
+            while (over && over.parentElement !== list) {
+                over = over.parentElement.closest(".todo-item");
+            }
+            if (!over || over === dragged) {
+                return;
+            }
+            const box = over.getBoundingClientRect();
+            const after = e.clientY > box.top + box.height / 2;
+            list.insertBefore(dragged, after ? over.nextElementSibling : over);
+        });
+
+        list.addEventListener("drop", (e) => {
+            if (dragged) {
+                e.preventDefault();
+            }
+        });
+    }
+
+    // rememberCollapsed keeps sub-item lists that were closed closed when the
+    // page is loaded again, as it is after every change.
+    function rememberCollapsed() {
+        const key = "todo-collapsed";
+        let closed;
+        try {
+            closed = new Set(JSON.parse(localStorage.getItem(key)) || []);
+        } catch {
+            closed = new Set();
+        }
+
+        for (const details of document.querySelectorAll("details.subitems")) {
+            if (closed.has(details.dataset.item)) {
+                details.open = false;
+            }
+            details.addEventListener("toggle", () => {
+                if (details.open) {
+                    closed.delete(details.dataset.item);
+                } else {
+                    closed.add(details.dataset.item);
+                }
+                localStorage.setItem(key, JSON.stringify(Array.from(closed)));
+            });
+        }
+    }
+})();